@@ -0,0 +1,329 @@
+// Package apu implements the audio processing unit of the NES, which is part
+// of the 2A03 (along with the Cpu).
+//
+// The APU has 5 channels: 2 pulse waves, a triangle wave, noise, and a delta
+// modulation channel (DMC) that plays samples from memory. The channels are
+// clocked by the Cpu clock, and modulated by a frame counter that runs at
+// roughly 240 Hz.
+
+package apu
+
+import (
+	"gone/cartridge"
+	"gone/mem"
+)
+
+// https://www.nesdev.org/wiki/APU
+// https://www.nesdev.org/wiki/APU_registers
+// https://www.nesdev.org/wiki/APU_Frame_Counter
+// https://www.nesdev.org/wiki/APU_Mixer
+
+const (
+	STATUS       = 0x4015 // read/write
+	FRAMECOUNTER = 0x4017 // write
+
+	oamDma = 0x4014
+	joy1   = 0x4016
+	joy2   = 0x4017 // read
+)
+
+// tables holds the timing of a Region, in Cpu cycles.
+type tables struct {
+	// frameSteps are the cycles at which the frame counter clocks the
+	// channels. The 4-step sequence ends after the 4th step, the 5-step
+	// sequence after the 5th.
+	frameSteps [5]int
+	noise      [16]uint16 // periods of the noise channel
+	dmc        [16]uint16 // periods of the DMC
+}
+
+var (
+	ntscTables = tables{
+		frameSteps: [5]int{7457, 14913, 22371, 29829, 37281},
+		noise:      [16]uint16{4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068},
+		dmc:        [16]uint16{428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54},
+	}
+	palTables = tables{
+		frameSteps: [5]int{8313, 16627, 24939, 33253, 41565},
+		noise:      [16]uint16{4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778},
+		dmc:        [16]uint16{398, 354, 316, 298, 276, 236, 210, 198, 176, 148, 132, 118, 98, 78, 66, 50},
+	}
+)
+
+// regionTables returns the tables of r. The Dendy's APU uses NTSC timing.
+func regionTables(r cartridge.Region) *tables {
+	if r == cartridge.PAL {
+		return &palTables
+	}
+	return &ntscTables
+}
+
+// An Apu is a mem.Device that claims all of the 2A03's I/O registers
+// (0x4000-0x4017). Since the registers of the APU, OAM DMA and the controller
+// ports are interleaved (0x4017 is even shared between the frame counter and
+// the 2nd controller), the Apu forwards accesses to the other two.
+//
+// The Apu must be clocked (via Step) once per Cpu cycle.
+type Apu struct {
+	// Region determines the timing of the frame counter, noise channel and
+	// DMC.
+	Region cartridge.Region
+
+	// Dma receives writes to 0x4014 (typically a cpu.Dma).
+	Dma mem.Device
+	// Input receives reads from 0x4016-0x4017, and writes to 0x4016
+	// (typically the controller ports).
+	Input mem.Device
+
+	// Irq is called whenever the IRQ output of the Apu changes. The output
+	// is active while either the frame counter or the DMC has an interrupt
+	// pending, until acknowledged by the program.
+	Irq func(active bool)
+	// Fetch is used by the DMC to read samples from memory (typically
+	// Cpu.Read).
+	Fetch func(addr uint16) byte
+	// Stall is used by the DMC to suspend the Cpu while reading
+	// (typically Cpu.Stall).
+	Stall func(cycles int)
+	// OpenBus returns the value of the data lines that the Apu does not
+	// drive (typically Bus.OpenBus). If nil, the high byte of the address
+	// is used, which is usually the last byte read.
+	OpenBus func() byte
+
+	tables       *tables          // of tablesRegion; nil until the first Step
+	tablesRegion cartridge.Region // what tables was looked up for
+
+	pulse1   pulse
+	pulse2   pulse
+	triangle triangle
+	noise    noise
+	dmc      dmc
+
+	cycle      uint64
+	frameCycle int
+	fiveStep   bool
+	irqInhibit bool
+	frameIrq   bool
+	irqOutput  bool
+}
+
+// New returns an Apu in its power-up state.
+func New() *Apu {
+	a := &Apu{}
+	a.Reset()
+	return a
+}
+
+// Reset silences all channels, and restarts the frame counter.
+func (a *Apu) Reset() {
+	a.pulse1 = pulse{sweep: sweep{onesComplement: true}}
+	a.pulse2 = pulse{}
+	a.triangle = triangle{}
+	a.noise = noise{shift: 1}
+	a.dmc = dmc{empty: true, bits: 8}
+	a.frameCycle = 0
+	a.frameIrq = false
+	a.updateIrq()
+}
+
+func (a *Apu) Range() (uint16, uint16) { return 0x4000, 0x4017 }
+
+// Read reads the status register (0x4015), or one of the controller ports.
+// Reading the status register acknowledges the frame interrupt, unless
+// readonly is true. All other registers are write-only.
+func (a *Apu) Read(addr uint16, readonly bool) byte {
+	switch addr {
+	case STATUS:
+		// IF-D NT21; bit 5 is open bus
+		data := a.openBus(addr)&0x20 |
+			b2i(a.dmc.interrupt)<<7 |
+			b2i(a.frameIrq)<<6 |
+			b2i(a.dmc.remaining > 0)<<4 |
+			b2i(a.noise.length.value > 0)<<3 |
+			b2i(a.triangle.length.value > 0)<<2 |
+			b2i(a.pulse2.length.value > 0)<<1 |
+			b2i(a.pulse1.length.value > 0)
+		if !readonly {
+			a.frameIrq = false
+			a.updateIrq()
+		}
+		return data
+	case joy1, joy2:
+		if a.Input != nil {
+			return a.Input.Read(addr, readonly)
+		}
+	}
+	return a.openBus(addr)
+}
+
+func (a *Apu) openBus(addr uint16) byte {
+	if a.OpenBus != nil {
+		return a.OpenBus()
+	}
+	return byte(addr >> 8)
+}
+
+func (a *Apu) Write(addr uint16, data byte) {
+	// several registers acknowledge interrupts
+	defer a.updateIrq()
+
+	switch {
+	case addr <= 0x4003:
+		a.pulse1.write(addr-0x4000, data)
+	case addr <= 0x4007:
+		a.pulse2.write(addr-0x4004, data)
+	case addr <= 0x400b:
+		a.triangle.write(addr-0x4008, data)
+	case addr <= 0x400f:
+		a.noise.write(addr-0x400c, data)
+	case addr <= 0x4013:
+		a.dmc.write(addr-0x4010, data)
+
+	case addr == oamDma:
+		if a.Dma != nil {
+			a.Dma.Write(addr, data)
+		}
+
+	case addr == STATUS: // ---D NT21
+		a.pulse1.length.setEnabled(data&0x01 > 0)
+		a.pulse2.length.setEnabled(data&0x02 > 0)
+		a.triangle.length.setEnabled(data&0x04 > 0)
+		a.noise.length.setEnabled(data&0x08 > 0)
+		a.dmc.interrupt = false
+		switch {
+		case data&0x10 == 0:
+			a.dmc.remaining = 0
+		case a.dmc.remaining == 0:
+			a.dmc.restart()
+			a.dmc.fetch(a)
+		}
+
+	case addr == joy1:
+		if a.Input != nil {
+			a.Input.Write(addr, data)
+		}
+
+	case addr == FRAMECOUNTER: // MI-- ----
+		a.fiveStep = data&0x80 > 0
+		a.irqInhibit = data&0x40 > 0
+		if a.irqInhibit {
+			a.frameIrq = false
+		}
+		// note: on hardware, the reset is delayed by 3-4 cycles
+		a.frameCycle = 0
+		if a.fiveStep {
+			a.quarterFrame()
+			a.halfFrame()
+		}
+	}
+}
+
+// updateIrq recomputes the IRQ output, and reports it if it has changed.
+func (a *Apu) updateIrq() {
+	active := a.frameIrq || a.dmc.interrupt
+	if active != a.irqOutput {
+		a.irqOutput = active
+		if a.Irq != nil {
+			a.Irq(active)
+		}
+	}
+}
+
+// quarterFrame clocks the envelopes and the triangle's linear counter.
+func (a *Apu) quarterFrame() {
+	a.pulse1.envelope.clock()
+	a.pulse2.envelope.clock()
+	a.noise.envelope.clock()
+	a.triangle.clockLinear()
+}
+
+// halfFrame clocks the length counters and sweeps.
+func (a *Apu) halfFrame() {
+	a.pulse1.length.clock()
+	a.pulse2.length.clock()
+	a.triangle.length.clock()
+	a.noise.length.clock()
+	a.pulse1.clockSweep()
+	a.pulse2.clockSweep()
+}
+
+func (a *Apu) clockFrameCounter(t *tables) {
+	frameSteps := t.frameSteps
+	a.frameCycle++
+	switch a.frameCycle {
+	case frameSteps[0], frameSteps[2]:
+		a.quarterFrame()
+	case frameSteps[1]:
+		a.quarterFrame()
+		a.halfFrame()
+	case frameSteps[3]:
+		if a.fiveStep {
+			return
+		}
+		a.quarterFrame()
+		a.halfFrame()
+		if !a.irqInhibit && !a.frameIrq {
+			a.frameIrq = true
+			a.updateIrq()
+		}
+		a.frameCycle = 0
+	case frameSteps[4]:
+		a.quarterFrame()
+		a.halfFrame()
+		a.frameCycle = 0
+	}
+}
+
+// currentTables returns the tables of a.Region, which are only looked up
+// again when the Region changes.
+func (a *Apu) currentTables() *tables {
+	if a.tables == nil || a.tablesRegion != a.Region {
+		a.tables, a.tablesRegion = regionTables(a.Region), a.Region
+	}
+	return a.tables
+}
+
+// Step advances the Apu by a single Cpu cycle.
+func (a *Apu) Step() {
+	t := a.currentTables()
+	a.triangle.clockTimer()
+	a.noise.clockTimer(t)
+	a.dmc.clockTimer(a, t)
+	if a.cycle%2 == 1 {
+		a.pulse1.clockTimer()
+		a.pulse2.clockTimer()
+	}
+	a.clockFrameCounter(t)
+	a.cycle++
+}
+
+// pulseTable and tndTable approximate the non-linear mixing of the channels.
+var (
+	pulseTable [31]float32
+	tndTable   [203]float32
+)
+
+func init() {
+	for i := range pulseTable {
+		pulseTable[i] = 95.52 / (8128/float32(i) + 100)
+	}
+	for i := range tndTable {
+		tndTable[i] = 163.67 / (24329/float32(i) + 100)
+	}
+}
+
+// Output mixes the current output of all channels into a single sample,
+// between 0 and 1. Samples are produced at the Cpu clock rate, and must be
+// resampled before playback (see Resampler).
+func (a *Apu) Output() float32 {
+	p := pulseTable[a.pulse1.output()+a.pulse2.output()]
+	tnd := tndTable[3*int(a.triangle.output())+2*int(a.noise.output())+int(a.dmc.output())]
+	return p + tnd
+}
+
+func b2i(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
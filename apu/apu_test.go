@@ -0,0 +1,229 @@
+package apu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gone/cartridge"
+	"gone/mem"
+)
+
+func TestLengthCounter(t *testing.T) {
+	a := New()
+	a.Write(0x4003, 0x08) // index 1, ignored while disabled
+	assert.Equal(t, a.Read(STATUS, true), byte(0))
+
+	a.Write(STATUS, 0x0f)
+	a.Write(0x4003, 0x08)
+	a.Write(0x400f, 0x18) // index 3: 2 half frames
+	assert.Equal(t, a.pulse1.length.value, byte(254))
+	assert.Equal(t, a.Read(STATUS, true), byte(0b1001))
+
+	for range ntscTables.frameSteps[3] {
+		a.Step()
+	}
+	assert.Equal(t, a.Read(STATUS, true)&0x0f, byte(0b0001))
+	assert.Equal(t, a.pulse1.length.value, byte(252))
+
+	a.Write(STATUS, 0x00)
+	assert.Equal(t, a.Read(STATUS, true)&0x0f, byte(0))
+}
+
+func TestFrameIrq(t *testing.T) {
+	a := New()
+	var irqs int
+	var line bool
+	a.Irq = func(active bool) {
+		if active {
+			irqs++
+		}
+		line = active
+	}
+
+	for range ntscTables.frameSteps[3] - 1 {
+		a.Step()
+	}
+	assert.Equal(t, irqs, 0)
+	a.Step()
+	assert.Equal(t, irqs, 1)
+	assert.True(t, line)
+
+	// reading the status acknowledges the interrupt, but peeking doesn't
+	assert.Equal(t, a.Read(STATUS, true)&0x40, byte(0x40))
+	assert.Equal(t, a.Read(STATUS, false)&0x40, byte(0x40))
+	assert.Equal(t, a.Read(STATUS, false)&0x40, byte(0))
+	assert.False(t, line)
+
+	// no interrupts when inhibited, or in 5-step mode
+	for _, mode := range []byte{0x40, 0x80} {
+		a.Write(FRAMECOUNTER, mode)
+		for range ntscTables.frameSteps[4] * 2 {
+			a.Step()
+		}
+		assert.Equal(t, irqs, 1)
+	}
+}
+
+func TestPulse(t *testing.T) {
+	a := New()
+	a.Write(STATUS, 0x01)
+	a.Write(0x4000, 0b1011_1111) // 50%, halt, constant volume 15
+	a.Write(0x4002, 0xfd)
+	a.Write(0x4003, 0x00)
+
+	// a period of 254 APU cycles, of which half are high
+	var high int
+	for range 254 * 2 * 8 {
+		a.Step()
+		if a.pulse1.output() > 0 {
+			high++
+		}
+	}
+	assert.Equal(t, high, 254*2*4)
+
+	// too low a period is muted
+	a.Write(0x4002, 0x07)
+	a.Write(0x4003, 0x00)
+	for range 100 {
+		a.Step()
+		assert.Equal(t, a.pulse1.output(), byte(0))
+	}
+}
+
+func TestMixer(t *testing.T) {
+	a := New()
+	// the triangle is held at the start of its waveform
+	assert.InDelta(t, a.Output(), tndTable[3*15], 0.0001)
+	assert.InDelta(t, pulseTable[15], 0.1488, 0.0001)
+	assert.InDelta(t, tndTable[202], 0.7425, 0.0001)
+}
+
+func TestSweep(t *testing.T) {
+	s := sweep{shift: 1, negate: true}
+	assert.Equal(t, s.target(0x100), uint16(0x080))
+	s.onesComplement = true
+	assert.Equal(t, s.target(0x100), uint16(0x07f))
+
+	s = sweep{shift: 1}
+	assert.False(t, s.muted(0x500))
+	assert.True(t, s.muted(0x600)) // target is 0x900
+	assert.True(t, s.muted(0x007))
+}
+
+func TestDmc(t *testing.T) {
+	a := New()
+	var reads []uint16
+	var stalls, irqs int
+	a.Fetch = func(addr uint16) byte {
+		reads = append(reads, addr)
+		return 0xff // all 1s; the level rises
+	}
+	a.Stall = func(cycles int) { stalls += cycles }
+	a.Irq = func(active bool) {
+		if active {
+			irqs++
+		}
+	}
+
+	a.Write(0x4010, 0x8f) // IRQ, fastest rate
+	a.Write(0x4011, 0x40)
+	a.Write(0x4012, 0x01) // 0xc040
+	a.Write(0x4013, 0x01) // 17 bytes
+	a.Write(STATUS, 0x10)
+
+	// the 1st byte is fetched immediately
+	assert.Equal(t, reads, []uint16{0xc040})
+	assert.Equal(t, stalls, dmcStall)
+	assert.Equal(t, a.Read(STATUS, true), byte(0x10))
+
+	for range 17 * 8 * 54 {
+		a.Step()
+	}
+	assert.Len(t, reads, 17)
+	assert.Equal(t, reads[16], uint16(0xc050))
+	assert.Equal(t, irqs, 1)
+	assert.Equal(t, a.Read(STATUS, true), byte(0x80))
+	assert.Greater(t, a.dmc.output(), byte(0x40))
+
+	// writing the status acknowledges the interrupt
+	a.Write(STATUS, 0x00)
+	assert.Equal(t, a.Read(STATUS, true), byte(0))
+}
+
+// register records the last write to a Device.
+type register struct{ addr, data byte }
+
+func (r *register) Range() (uint16, uint16)              { return 0, 0 }
+func (r *register) Read(addr uint16, readonly bool) byte { return r.data }
+func (r *register) Write(addr uint16, data byte)         { r.addr, r.data = byte(addr), data }
+
+func TestForwarding(t *testing.T) {
+	a := New()
+	dma, input := &register{}, &register{data: 0x41}
+	a.Dma, a.Input = dma, input
+
+	a.Write(0x4014, 0x02)
+	assert.Equal(t, *dma, register{0x14, 0x02})
+	a.Write(0x4016, 0x01)
+	assert.Equal(t, *input, register{0x16, 0x01})
+
+	// 0x4017 is read from the controller, but written to the frame
+	// counter
+	a.Write(0x4017, 0x40)
+	assert.Equal(t, input.addr, byte(0x16))
+	assert.True(t, a.irqInhibit)
+	assert.Equal(t, a.Read(0x4017, false), byte(0x01))
+
+	b := &mem.Bus{}
+	assert.NoError(t, b.Register(a))
+	assert.Equal(t, b.Read(0x4000, false), byte(0x40)) // write-only
+}
+
+func TestOpenBus(t *testing.T) {
+	a := New()
+	b := &mem.Bus{}
+	assert.NoError(t, b.Register(a))
+	a.OpenBus = b.OpenBus
+
+	b.Ram[0x10] = 0xff
+	b.Read(0x0010, false)
+	assert.Equal(t, b.Read(STATUS, false), byte(0x20)) // only bit 5
+	assert.Equal(t, b.Read(0x4000, false), byte(0x20)) // write-only
+
+	b.Ram[0x10] = 0xdf
+	b.Read(0x0010, false)
+	assert.Equal(t, b.Read(STATUS, false), byte(0))
+}
+
+func TestRegion(t *testing.T) {
+	a := New()
+	a.Region = cartridge.PAL
+	var irqs int
+	a.Irq = func(active bool) {
+		if active {
+			irqs++
+		}
+	}
+	for range 33253 - 1 {
+		a.Step()
+	}
+	assert.Equal(t, irqs, 0)
+	a.Step()
+	assert.Equal(t, irqs, 1)
+
+	// the noise channel reloads its timer from the PAL table
+	a.Write(0x400e, 0x0f)
+	a.noise.timer = 0
+	a.Step()
+	assert.Equal(t, a.noise.timer, uint16(3778-1))
+
+	// and picks up a change of Region at the next Step
+	a.Region = cartridge.NTSC
+	a.noise.timer = 0
+	a.Step()
+	assert.Equal(t, a.noise.timer, uint16(4068-1))
+
+	// the zero value runs as NTSC
+	assert.NotPanics(t, func() { (&Apu{}).Step() })
+}
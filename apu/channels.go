@@ -0,0 +1,185 @@
+package apu
+
+// https://www.nesdev.org/wiki/APU_Pulse
+// https://www.nesdev.org/wiki/APU_Triangle
+// https://www.nesdev.org/wiki/APU_Noise
+
+// dutyTable holds the 4 waveforms of the pulse channels: 12.5%, 25%, 50% and
+// 25% negated.
+var dutyTable = [4][8]byte{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// A pulse channel produces a square wave with variable duty cycle.
+type pulse struct {
+	length   lengthCounter
+	envelope envelope
+	sweep    sweep
+
+	duty     byte
+	sequence byte   // position in the waveform (0-7)
+	period   uint16 // 11 bits
+	timer    uint16
+}
+
+// write handles the 4 registers of the channel (reg 0-3).
+func (p *pulse) write(reg uint16, data byte) {
+	switch reg {
+	case 0: // DDLC VVVV
+		p.duty = data >> 6
+		p.length.halt = data&0x20 > 0
+		p.envelope.loop = data&0x20 > 0
+		p.envelope.constant = data&0x10 > 0
+		p.envelope.period = data & 0x0f
+	case 1: // EPPP NSSS
+		p.sweep.enabled = data&0x80 > 0
+		p.sweep.period = (data >> 4) & 0x07
+		p.sweep.negate = data&0x08 > 0
+		p.sweep.shift = data & 0x07
+		p.sweep.reload = true
+	case 2: // timer low
+		p.period = p.period&0x0700 | uint16(data)
+	case 3: // LLLL LTTT
+		p.period = p.period&0x00ff | uint16(data&0x07)<<8
+		p.length.load(data >> 3)
+		p.envelope.start = true
+		p.sequence = 0
+	}
+}
+
+// clockTimer is called every APU cycle (i.e. every other Cpu cycle).
+func (p *pulse) clockTimer() {
+	if p.timer == 0 {
+		p.timer = p.period
+		p.sequence = (p.sequence + 1) % 8
+	} else {
+		p.timer--
+	}
+}
+
+func (p *pulse) clockSweep() { p.period = p.sweep.clock(p.period) }
+
+func (p *pulse) output() byte {
+	if p.length.value == 0 || p.sweep.muted(p.period) || dutyTable[p.duty][p.sequence] == 0 {
+		return 0
+	}
+	return p.envelope.volume()
+}
+
+// triangleTable is the 32-step waveform of the triangle channel.
+var triangleTable = [32]byte{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// A triangle channel produces a triangle wave of fixed volume. In addition to
+// the length counter, it has a finer grained linear counter.
+type triangle struct {
+	length lengthCounter
+
+	control       bool // also the length counter halt flag
+	linearPeriod  byte
+	linearCounter byte
+	linearReload  bool
+
+	sequence byte // 0-31
+	period   uint16
+	timer    uint16
+}
+
+func (t *triangle) write(reg uint16, data byte) {
+	switch reg {
+	case 0: // CRRR RRRR
+		t.control = data&0x80 > 0
+		t.length.halt = t.control
+		t.linearPeriod = data & 0x7f
+	case 2:
+		t.period = t.period&0x0700 | uint16(data)
+	case 3:
+		t.period = t.period&0x00ff | uint16(data&0x07)<<8
+		t.length.load(data >> 3)
+		t.linearReload = true
+	}
+}
+
+// clockTimer is called every Cpu cycle. The waveform only advances while both
+// counters are nonzero; otherwise, the output is held (rather than silenced),
+// which avoids popping.
+func (t *triangle) clockTimer() {
+	if t.timer > 0 {
+		t.timer--
+		return
+	}
+	t.timer = t.period
+	if t.length.value > 0 && t.linearCounter > 0 {
+		t.sequence = (t.sequence + 1) % 32
+	}
+}
+
+// clockLinear is called every quarter frame.
+func (t *triangle) clockLinear() {
+	if t.linearReload {
+		t.linearCounter = t.linearPeriod
+	} else if t.linearCounter > 0 {
+		t.linearCounter--
+	}
+	if !t.control {
+		t.linearReload = false
+	}
+}
+
+func (t *triangle) output() byte { return triangleTable[t.sequence] }
+
+// A noise channel produces pseudo-random noise from a 15-bit linear feedback
+// shift register.
+type noise struct {
+	length   lengthCounter
+	envelope envelope
+
+	mode  bool   // short mode; feedback from bit 6 instead of bit 1
+	shift uint16 // 15 bits, never 0
+	rate  byte   // index into the period table of the Region
+	timer uint16
+}
+
+func (n *noise) write(reg uint16, data byte) {
+	switch reg {
+	case 0: // --LC VVVV
+		n.length.halt = data&0x20 > 0
+		n.envelope.loop = data&0x20 > 0
+		n.envelope.constant = data&0x10 > 0
+		n.envelope.period = data & 0x0f
+	case 2: // M--- PPPP
+		n.mode = data&0x80 > 0
+		n.rate = data & 0x0f
+	case 3: // LLLL L---
+		n.length.load(data >> 3)
+		n.envelope.start = true
+	}
+}
+
+// clockTimer is called every Cpu cycle.
+func (n *noise) clockTimer(t *tables) {
+	if n.timer > 0 {
+		n.timer--
+		return
+	}
+	n.timer = t.noise[n.rate] - 1
+
+	tap := 1
+	if n.mode {
+		tap = 6
+	}
+	feedback := (n.shift ^ n.shift>>tap) & 1
+	n.shift = n.shift>>1 | feedback<<14
+}
+
+func (n *noise) output() byte {
+	if n.length.value == 0 || n.shift&1 > 0 {
+		return 0
+	}
+	return n.envelope.volume()
+}
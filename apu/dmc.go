@@ -0,0 +1,128 @@
+package apu
+
+// https://www.nesdev.org/wiki/APU_DMC
+
+// dmcStall is the number of cycles for which the Cpu is suspended while the
+// DMC fetches a byte. The real value varies from 1 to 4, depending on what
+// the Cpu is doing at the time.
+const dmcStall = 4
+
+// A dmc (delta modulation channel) plays 1-bit delta-encoded samples that are
+// read directly from memory (0xc000-0xffff), which suspends the Cpu briefly.
+// Alternatively, the output level can be written directly, which is how most
+// games play PCM samples.
+type dmc struct {
+	irqEnabled bool
+	loop       bool
+	rate       byte // index into the period table of the Region
+	timer      uint16
+
+	level byte // 7 bits
+
+	sampleAddr   uint16
+	sampleLength uint16
+
+	// memory reader
+	addr      uint16
+	remaining uint16 // bytes remaining
+	buffer    byte
+	empty     bool // the buffer is empty
+
+	// output unit
+	shift     byte
+	bits      byte // bits remaining
+	silence   bool
+	interrupt bool
+}
+
+func (d *dmc) write(reg uint16, data byte) {
+	switch reg {
+	case 0: // IL-- RRRR
+		d.irqEnabled = data&0x80 > 0
+		if !d.irqEnabled {
+			d.interrupt = false
+		}
+		d.loop = data&0x40 > 0
+		d.rate = data & 0x0f
+	case 1: // -DDD DDDD
+		d.level = data & 0x7f
+	case 2: // AAAA AAAA
+		d.sampleAddr = 0xc000 | uint16(data)<<6
+	case 3: // LLLL LLLL
+		d.sampleLength = uint16(data)<<4 | 1
+	}
+}
+
+// restart begins playback of the sample from the start.
+func (d *dmc) restart() {
+	d.addr = d.sampleAddr
+	d.remaining = d.sampleLength
+}
+
+// fetch fills the sample buffer, if it is empty and there are bytes
+// remaining. The read is performed by a, since it has access to the Bus.
+func (d *dmc) fetch(a *Apu) {
+	if !d.empty || d.remaining == 0 {
+		return
+	}
+	if a.Stall != nil {
+		a.Stall(dmcStall)
+	}
+	if a.Fetch != nil {
+		d.buffer = a.Fetch(d.addr)
+	}
+	d.empty = false
+
+	d.addr++
+	if d.addr == 0 {
+		d.addr = 0x8000
+	}
+	d.remaining--
+	if d.remaining == 0 {
+		switch {
+		case d.loop:
+			d.restart()
+		case d.irqEnabled:
+			d.interrupt = true
+			a.updateIrq()
+		}
+	}
+}
+
+// clockTimer is called every Cpu cycle.
+func (d *dmc) clockTimer(a *Apu, t *tables) {
+	if d.timer > 0 {
+		d.timer--
+		return
+	}
+	d.timer = t.dmc[d.rate] - 1
+
+	if !d.silence {
+		if d.shift&1 > 0 {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else if d.level >= 2 {
+			d.level -= 2
+		}
+	}
+	d.shift >>= 1
+
+	if d.bits > 0 {
+		d.bits--
+	}
+	if d.bits == 0 {
+		// start a new output cycle
+		d.bits = 8
+		if d.empty {
+			d.silence = true
+		} else {
+			d.silence = false
+			d.shift = d.buffer
+			d.empty = true
+			d.fetch(a)
+		}
+	}
+}
+
+func (d *dmc) output() byte { return d.level }
@@ -0,0 +1,147 @@
+package apu
+
+import "math"
+
+// https://www.nesdev.org/wiki/APU_Mixer#Emulation
+// https://en.wikipedia.org/wiki/Low-pass_filter#Discrete-time_realization
+
+// filter is a first-order IIR filter.
+type filter struct {
+	b0, b1, a1 float32
+	prevX      float32
+	prevY      float32
+}
+
+func lowPass(sampleRate float64, cutoff float64) filter {
+	c := float32(sampleRate / math.Pi / cutoff)
+	a0i := 1 / (1 + c)
+	return filter{b0: a0i, b1: a0i, a1: (1 - c) * a0i}
+}
+
+func highPass(sampleRate float64, cutoff float64) filter {
+	c := float32(sampleRate / math.Pi / cutoff)
+	a0i := 1 / (1 + c)
+	return filter{b0: c * a0i, b1: -c * a0i, a1: (1 - c) * a0i}
+}
+
+func (f *filter) step(x float32) float32 {
+	y := f.b0*x + f.b1*f.prevX - f.a1*f.prevY
+	f.prevX = x
+	f.prevY = y
+	return y
+}
+
+// http://slack.net/~ant/bl-synth/
+//
+// the output of the Apu is a sum of square steps, and it only changes on a
+// small fraction of cycles. instead of filtering every input sample, each
+// change is added to the output as a band-limited step: a step whose
+// frequency content stops short of the output Nyquist frequency, i.e. a
+// windowed sinc, integrated. since the steps can occur anywhere between 2
+// output samples, the kernel is precomputed for a number of phases.
+
+const (
+	stepWidth  = 16  // output samples on either side of a step
+	stepPhases = 256 // resolution of a step's position between 2 samples
+	stepCutoff = 0.9 // fraction of the output Nyquist frequency
+
+	// a power of 2, large enough for all the samples that may still
+	// receive a step
+	stepBufSize = 64
+)
+
+// stepKernel holds, for each phase, the difference between consecutive
+// samples of a band-limited step (i.e. the windowed sinc itself). Each phase
+// sums to 1, so that a step of d eventually adds exactly d to the output.
+var stepKernel = func() (k [stepPhases][2 * stepWidth]float64) {
+	for p := range stepPhases {
+		frac := float64(p) / stepPhases
+		var sum float64
+		for i := range k[p] {
+			x := float64(i-stepWidth+1) - frac // distance to the step
+			sinc := 1.0
+			if x != 0 {
+				sinc = math.Sin(math.Pi*stepCutoff*x) / (math.Pi * stepCutoff * x)
+			}
+			// Blackman window, over (-stepWidth, stepWidth)
+			w := 0.42 + 0.5*math.Cos(math.Pi*x/stepWidth) + 0.08*math.Cos(2*math.Pi*x/stepWidth)
+			k[p][i] = sinc * w
+			sum += k[p][i]
+		}
+		for i := range k[p] {
+			k[p][i] /= sum
+		}
+	}
+	return k
+}()
+
+// A Resampler converts the output of the Apu (one sample per Cpu cycle, i.e.
+// ~1.79 MHz) to a rate suitable for playback (e.g. 44.1 or 48 kHz).
+//
+// Each change of the input is synthesised as a band-limited step, so the
+// output contains (almost) nothing above its Nyquist frequency, and does not
+// alias. The result is then passed through the same filters as the NES's own
+// output stage: 2 high-pass filters (90 Hz and 440 Hz), which remove the DC
+// offset, and a low-pass filter (14 kHz). The output lags the input by
+// stepWidth samples.
+type Resampler struct {
+	// Samples holds the resampled output, between -1 and 1. The caller is
+	// expected to consume (and truncate) it regularly.
+	Samples []float32
+
+	ratio float64 // output samples per input sample
+	time  float64 // of the next input sample, in output samples
+	next  int     // the next output sample
+
+	last  float32 // the last input sample
+	level float64 // the output, before filtering
+	steps [stepBufSize]float64
+
+	filters [3]filter
+}
+
+// NewResampler returns a Resampler converting samples produced at clockRate
+// (the Cpu clock rate, in Hz) to sampleRate.
+func NewResampler(clockRate float64, sampleRate float64) *Resampler {
+	return &Resampler{
+		ratio: sampleRate / clockRate,
+		time:  stepWidth,
+		filters: [3]filter{
+			highPass(sampleRate, 90),
+			highPass(sampleRate, 440),
+			lowPass(sampleRate, 14000),
+		},
+	}
+}
+
+// Add adds a single sample (typically Apu.Output), produced at the clock rate.
+func (r *Resampler) Add(sample float32) {
+	if sample != r.last {
+		r.step(float64(sample - r.last))
+		r.last = sample
+	}
+	r.time += r.ratio
+
+	// a sample is complete once no later step can reach it
+	for float64(r.next+stepWidth) <= r.time {
+		r.level += r.steps[r.next%stepBufSize]
+		r.steps[r.next%stepBufSize] = 0
+		r.next++
+
+		out := float32(r.level)
+		for i := range r.filters {
+			out = r.filters[i].step(out)
+		}
+		r.Samples = append(r.Samples, max(-1, min(out, 1)))
+	}
+}
+
+// step adds a band-limited step of d at the current time.
+func (r *Resampler) step(d float64) {
+	n := math.Floor(r.time)
+	kernel := &stepKernel[int((r.time-n)*stepPhases)]
+	first := int(n) - stepWidth + 1
+	for i, k := range kernel {
+		r.steps[(first+i)%stepBufSize] += d * k
+	}
+}
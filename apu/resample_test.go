@@ -0,0 +1,92 @@
+package apu
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const clockRate = 1789773
+
+func TestResampler(t *testing.T) {
+	r := NewResampler(clockRate, 44100)
+	for range clockRate {
+		r.Add(0.5)
+	}
+	assert.InDelta(t, len(r.Samples), 44100, 1)
+	// the DC offset is removed by the high-pass filters
+	assert.InDelta(t, r.Samples[len(r.Samples)-1], 0, 0.001)
+
+	// a 1 kHz square wave passes through
+	r = NewResampler(clockRate, 48000)
+	for i := range clockRate / 10 {
+		if (i*2000/clockRate)%2 == 0 {
+			r.Add(0.5)
+		} else {
+			r.Add(0)
+		}
+	}
+	assert.InDelta(t, len(r.Samples), 4800, 1)
+	var peak float64
+	for _, s := range r.Samples {
+		peak = max(peak, math.Abs(float64(s)))
+	}
+	assert.Greater(t, peak, 0.2)
+}
+
+// peak returns the largest amplitude of a sine of the given frequency, after
+// resampling to 44.1 kHz. The first samples, in which the filters settle, are
+// ignored.
+func peak(freq float64) float64 {
+	r := NewResampler(clockRate, 44100)
+	for i := range clockRate / 10 {
+		r.Add(float32(0.5 + 0.5*math.Sin(2*math.Pi*freq*float64(i)/clockRate)))
+	}
+	var p float64
+	for _, s := range r.Samples[1000:] {
+		p = max(p, math.Abs(float64(s)))
+	}
+	return p
+}
+
+func TestResamplerAliasing(t *testing.T) {
+	// the pass band is only shaped by the output filters
+	assert.InDelta(t, peak(1000), 0.455, 0.005)
+	assert.InDelta(t, peak(10000), 0.41, 0.005)
+
+	// above the output Nyquist frequency (22.05 kHz), almost nothing is
+	// left, not even an alias (e.g. at 44.1-30 = 14.1 kHz)
+	assert.Less(t, peak(23000), 0.001)
+	assert.Less(t, peak(30000), 0.001)
+	assert.Less(t, peak(100000), 0.001)
+}
+
+func TestWavWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+	assert.NoError(t, err)
+	defer f.Close()
+
+	w, err := NewWavWriter(f, 44100)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Write([]float32{0, 1, -1, 2}))
+	assert.NoError(t, w.Write([]float32{0.5}))
+	assert.NoError(t, w.Close())
+
+	b, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Len(t, b, wavHeaderSize+5*2)
+	assert.Equal(t, string(b[0:4]), "RIFF")
+	assert.Equal(t, b[4:8], []byte{36 + 10, 0, 0, 0})
+	assert.Equal(t, b[24:28], []byte{0x44, 0xac, 0, 0}) // 44100
+	assert.Equal(t, b[40:44], []byte{10, 0, 0, 0})
+	assert.Equal(t, b[44:], []byte{
+		0x00, 0x00,
+		0xff, 0x7f,
+		0x01, 0x80, // -32767
+		0xff, 0x7f, // clamped
+		0xff, 0x3f,
+	})
+}
@@ -0,0 +1,129 @@
+package apu
+
+// The building blocks shared by several channels.
+//
+// https://www.nesdev.org/wiki/APU_Envelope
+// https://www.nesdev.org/wiki/APU_Length_Counter
+// https://www.nesdev.org/wiki/APU_Sweep
+
+// lengthTable maps the 5-bit index written to a channel's length register to
+// the number of half frames that the channel will play for.
+var lengthTable = [32]byte{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// A lengthCounter silences a channel after a given number of half frames.
+type lengthCounter struct {
+	enabled bool // via 0x4015; a disabled counter is held at 0
+	halt    bool
+	value   byte
+}
+
+func (l *lengthCounter) load(index byte) {
+	if l.enabled {
+		l.value = lengthTable[index&0x1f]
+	}
+}
+
+func (l *lengthCounter) setEnabled(enabled bool) {
+	l.enabled = enabled
+	if !enabled {
+		l.value = 0
+	}
+}
+
+// clock is called every half frame.
+func (l *lengthCounter) clock() {
+	if !l.halt && l.value > 0 {
+		l.value--
+	}
+}
+
+// An envelope produces either a constant volume, or a decaying 'saw' from 15
+// to 0, optionally looping.
+type envelope struct {
+	start    bool
+	loop     bool // shared with the length counter halt flag
+	constant bool
+	period   byte // also the constant volume
+
+	divider byte
+	decay   byte
+}
+
+// clock is called every quarter frame.
+func (e *envelope) clock() {
+	switch {
+	case e.start:
+		e.start = false
+		e.decay = 15
+		e.divider = e.period
+	case e.divider > 0:
+		e.divider--
+	default:
+		e.divider = e.period
+		if e.decay > 0 {
+			e.decay--
+		} else if e.loop {
+			e.decay = 15
+		}
+	}
+}
+
+func (e *envelope) volume() byte {
+	if e.constant {
+		return e.period
+	}
+	return e.decay
+}
+
+// A sweep periodically adjusts the period of a pulse channel, bending its
+// pitch up or down.
+type sweep struct {
+	enabled bool
+	period  byte
+	negate  bool
+	shift   byte
+	reload  bool
+	divider byte
+
+	// pulse 1 negates with one's complement (i.e. subtracts an extra 1),
+	// pulse 2 with two's complement
+	onesComplement bool
+}
+
+// target returns the period that the sweep would change timer to.
+func (s *sweep) target(timer uint16) uint16 {
+	change := timer >> s.shift
+	if !s.negate {
+		return timer + change
+	}
+	if s.onesComplement {
+		change++
+	}
+	if change > timer {
+		return 0
+	}
+	return timer - change
+}
+
+// muted reports whether the channel is silenced, which happens whenever the
+// period is too low or the target is too high, even if the sweep is disabled.
+func (s *sweep) muted(timer uint16) bool {
+	return timer < 8 || s.target(timer) > 0x7ff
+}
+
+// clock is called every half frame, and returns the new timer period.
+func (s *sweep) clock(timer uint16) uint16 {
+	if s.divider == 0 && s.enabled && s.shift > 0 && !s.muted(timer) {
+		timer = s.target(timer)
+	}
+	if s.divider == 0 || s.reload {
+		s.divider = s.period
+		s.reload = false
+	} else {
+		s.divider--
+	}
+	return timer
+}
@@ -0,0 +1,86 @@
+package apu
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// http://soundfile.sapp.org/doc/WaveFormat/
+
+const wavHeaderSize = 44
+
+// A WavWriter writes samples to a mono, 16-bit PCM WAV file. Since the length
+// of the audio is not known in advance, the header is completed by Close.
+// Output is deterministic, so recordings of the same run can be compared
+// byte for byte.
+type WavWriter struct {
+	w          io.WriteSeeker
+	sampleRate uint32
+	n          uint32 // bytes of sample data written
+	buf        []byte
+}
+
+// NewWavWriter writes a placeholder header to w, which must be positioned at
+// the start of the file.
+func NewWavWriter(w io.WriteSeeker, sampleRate int) (*WavWriter, error) {
+	ww := &WavWriter{w: w, sampleRate: uint32(sampleRate)}
+	if err := ww.header(); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (ww *WavWriter) header() error {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+		blockAlign    = channels * bitsPerSample / 8
+	)
+	h := make([]byte, 0, wavHeaderSize)
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, 36+ww.n)
+	h = append(h, "WAVE"...)
+
+	h = append(h, "fmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) // PCM
+	h = binary.LittleEndian.AppendUint16(h, channels)
+	h = binary.LittleEndian.AppendUint32(h, ww.sampleRate)
+	h = binary.LittleEndian.AppendUint32(h, ww.sampleRate*blockAlign)
+	h = binary.LittleEndian.AppendUint16(h, blockAlign)
+	h = binary.LittleEndian.AppendUint16(h, bitsPerSample)
+
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, ww.n)
+
+	_, err := ww.w.Write(h)
+	return err
+}
+
+// Write writes samples (between -1 and 1) to the file.
+func (ww *WavWriter) Write(samples []float32) error {
+	ww.buf = ww.buf[:0]
+	for _, s := range samples {
+		s = max(-1, min(s, 1))
+		ww.buf = binary.LittleEndian.AppendUint16(ww.buf, uint16(int16(s*32767)))
+	}
+	if uint64(ww.n)+uint64(len(ww.buf)) > 1<<32-1-36 {
+		return errors.New("wav: file too large")
+	}
+	n, err := ww.w.Write(ww.buf)
+	ww.n += uint32(n)
+	return err
+}
+
+// Close completes the header. The underlying writer is not closed.
+func (ww *WavWriter) Close() error {
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ww.header(); err != nil {
+		return err
+	}
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,297 @@
+// Package cartridge loads NES game cartridges from iNES (.nes) files.
+//
+// Both the original iNES format and its successor, NES 2.0, are supported.
+// The two share a 16-byte header, followed by an optional trainer, PRG-ROM,
+// and (optional) CHR-ROM.
+
+package cartridge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gone/mask"
+)
+
+// https://www.nesdev.org/wiki/INES
+// https://www.nesdev.org/wiki/NES_2.0
+
+const (
+	headerSize  = 16
+	trainerSize = 512
+	prgRomUnit  = 16 * 1024 // size units used by iNES 1.0
+	chrRomUnit  = 8 * 1024
+	prgRamUnit  = 8 * 1024
+
+	// the largest PRG-ROM in the plain notation of NES 2.0 is 0xeff * 16
+	// kB, just under 64 MB; no real cartridge comes close
+	maxRomSize = 64 * 1024 * 1024
+)
+
+var magic = []byte("NES\x1a")
+
+// Mirroring describes how the 2 kB of VRAM in the console is arranged into
+// the 4 logical nametables of the PPU.
+//
+// https://www.nesdev.org/wiki/Mirroring#Nametable_Mirroring
+type Mirroring byte
+
+const (
+	Horizontal Mirroring = iota // vertical arrangement; used by vertically scrolling games
+	Vertical                    // horizontal arrangement; used by horizontally scrolling games
+	FourScreen                  // the cartridge provides an extra 2 kB of VRAM
+
+	// these cannot be declared in a header, but can be selected at
+	// runtime by some mappers (e.g. MMC1)
+
+	SingleScreenLower // all 4 nametables use the first 1 kB of VRAM
+	SingleScreenUpper // all 4 nametables use the second 1 kB of VRAM
+)
+
+func (m Mirroring) String() string {
+	switch m {
+	case Horizontal:
+		return "horizontal"
+	case Vertical:
+		return "vertical"
+	case FourScreen:
+		return "four-screen"
+	case SingleScreenLower:
+		return "single-screen (lower)"
+	case SingleScreenUpper:
+		return "single-screen (upper)"
+	default:
+		return fmt.Sprintf("Mirroring(%d)", m)
+	}
+}
+
+// A Region is the television system that a cartridge was made for. This
+// determines the timing of the CPU and PPU.
+//
+// https://www.nesdev.org/wiki/NES_2.0#Byte_12_(CPU/PPU_Timing)
+type Region byte
+
+const (
+	NTSC  Region = iota // North America, Japan
+	PAL                 // Western Europe, Australia
+	Multi               // works with either NTSC or PAL timing
+	Dendy               // Russian famiclone
+)
+
+func (r Region) String() string {
+	switch r {
+	case NTSC:
+		return "NTSC"
+	case PAL:
+		return "PAL"
+	case Multi:
+		return "multi-region"
+	case Dendy:
+		return "Dendy"
+	default:
+		return fmt.Sprintf("Region(%d)", r)
+	}
+}
+
+// A Header describes the contents of a cartridge. All sizes are in bytes.
+type Header struct {
+	Nes2 bool // if false, the file is in the original iNES format
+
+	PrgRomSize int
+	ChrRomSize int // if 0, the cartridge uses CHR-RAM instead
+
+	PrgRamSize   int // volatile
+	PrgNvramSize int // battery-backed
+	ChrRamSize   int
+	ChrNvramSize int
+
+	Mapper    uint16 // 0-4095; only 0-255 in iNES 1.0
+	Submapper byte   // 0-15; NES 2.0 only
+
+	Mirroring Mirroring
+	Battery   bool // the cartridge contains battery-backed memory
+	Trainer   bool // a 512-byte trainer precedes the PRG-ROM
+	Region    Region
+}
+
+// A Cartridge contains the raw ROM data of a game. Bank switching (i.e.
+// mapping ROM onto a Bus) is the responsibility of a mapper.
+type Cartridge struct {
+	Header
+
+	TrainerData []byte // loaded at 0x7000-0x71ff, if present
+	PrgRom      []byte
+	ChrRom      []byte
+}
+
+// ParseHeader decodes the 16-byte header of an iNES or NES 2.0 file.
+func ParseHeader(h []byte) (Header, error) {
+	if len(h) < headerSize {
+		return Header{}, fmt.Errorf("header is %d bytes, expected %d", len(h), headerSize)
+	}
+	if !bytes.Equal(h[:4], magic) {
+		return Header{}, fmt.Errorf("not an iNES file: bad magic %q", h[:4])
+	}
+
+	flags6 := h[6]
+	flags7 := h[7]
+
+	hdr := Header{
+		Battery: mask.IsSet(flags6, 7),
+		Trainer: mask.IsSet(flags6, 6),
+		Mapper:  uint16(mask.First(flags6, 4)),
+		Nes2:    mask.Range(flags7, 5, 6) == 0b10,
+	}
+
+	switch {
+	case mask.IsSet(flags6, 5):
+		hdr.Mirroring = FourScreen
+	case mask.IsSet(flags6, 8):
+		hdr.Mirroring = Vertical
+	default:
+		hdr.Mirroring = Horizontal
+	}
+
+	if hdr.Nes2 {
+		return parseNes2(h, hdr)
+	}
+
+	// some old dumping tools wrote junk (e.g. "DiskDude!") into bytes
+	// 7-15; if so, the upper nibble of the mapper number cannot be
+	// trusted
+	if bytes.Equal(h[12:16], []byte{0, 0, 0, 0}) {
+		hdr.Mapper |= uint16(mask.First(flags7, 4)) << 4
+		if mask.IsSet(h[9], 8) {
+			hdr.Region = PAL
+		}
+	}
+
+	hdr.PrgRomSize = int(h[4]) * prgRomUnit
+	hdr.ChrRomSize = int(h[5]) * chrRomUnit
+
+	// 0 means 8 kB, for compatibility
+	prgRam := max(int(h[8]), 1) * prgRamUnit
+	if hdr.Battery {
+		hdr.PrgNvramSize = prgRam
+	} else {
+		hdr.PrgRamSize = prgRam
+	}
+	if hdr.ChrRomSize == 0 {
+		hdr.ChrRamSize = chrRomUnit
+	}
+
+	return hdr, nil
+}
+
+func parseNes2(h []byte, hdr Header) (Header, error) {
+	hdr.Mapper |= uint16(mask.First(h[7], 4))<<4 | uint16(mask.Last(h[8], 4))<<8
+	hdr.Submapper = mask.First(h[8], 4)
+
+	var err error
+	hdr.PrgRomSize, err = romSize(h[4], mask.Last(h[9], 4), prgRomUnit)
+	if err != nil {
+		return Header{}, fmt.Errorf("PRG-ROM: %w", err)
+	}
+	hdr.ChrRomSize, err = romSize(h[5], mask.First(h[9], 4), chrRomUnit)
+	if err != nil {
+		return Header{}, fmt.Errorf("CHR-ROM: %w", err)
+	}
+
+	hdr.PrgRamSize = ramSize(mask.Last(h[10], 4))
+	hdr.PrgNvramSize = ramSize(mask.First(h[10], 4))
+	hdr.ChrRamSize = ramSize(mask.Last(h[11], 4))
+	hdr.ChrNvramSize = ramSize(mask.First(h[11], 4))
+
+	hdr.Region = Region(mask.Last(h[12], 2))
+
+	return hdr, nil
+}
+
+// romSize decodes a NES 2.0 ROM size from its LSB and MSB (nibble). If the MSB
+// is 0xf, the LSB is in exponent-multiplier notation (EEEEEEMM), which is
+// used for sizes that are not a multiple of unit.
+func romSize(lsb byte, msb byte, unit int) (int, error) {
+	if msb != 0xf {
+		return (int(msb)<<8 | int(lsb)) * unit, nil
+	}
+	exp := mask.First(lsb, 6)
+	mul := int(mask.Last(lsb, 2))*2 + 1
+	if exp > 30 || (1<<exp)*mul > maxRomSize {
+		return 0, fmt.Errorf("size 2^%d*%d is too large", exp, mul)
+	}
+	return (1 << exp) * mul, nil
+}
+
+// ramSize decodes a NES 2.0 RAM size from its shift count. 0 means no RAM.
+func ramSize(shift byte) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+// Load reads a cartridge from an iNES or NES 2.0 file. Any data following the
+// CHR-ROM (e.g. PlayChoice-10 ROMs) is ignored.
+func Load(r io.Reader) (*Cartridge, error) {
+	h := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	hdr, err := ParseHeader(h)
+	if err != nil {
+		return nil, err
+	}
+
+	if hdr.PrgRomSize == 0 {
+		return nil, errors.New("header declares no PRG-ROM")
+	}
+
+	cart := Cartridge{Header: hdr}
+
+	// the buffer only grows as data arrives, so that a bogus header cannot
+	// make us allocate more than the file holds
+	read := func(name string, size int) ([]byte, error) {
+		b, err := io.ReadAll(io.LimitReader(r, int64(size)))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < size {
+			return nil, fmt.Errorf(
+				"header declares %d bytes of %s, but only %d remain",
+				size, name, len(b),
+			)
+		}
+		return b, nil
+	}
+
+	if hdr.Trainer {
+		if cart.TrainerData, err = read("trainer", trainerSize); err != nil {
+			return nil, err
+		}
+	}
+	if cart.PrgRom, err = read("PRG-ROM", hdr.PrgRomSize); err != nil {
+		return nil, err
+	}
+	if cart.ChrRom, err = read("CHR-ROM", hdr.ChrRomSize); err != nil {
+		return nil, err
+	}
+
+	return &cart, nil
+}
+
+// Open reads a cartridge from the .nes file at path.
+func Open(path string) (*Cartridge, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cart, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cart, nil
+}
@@ -0,0 +1,123 @@
+package cartridge
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rom builds an iNES file from a header and the given PRG/CHR sizes. The
+// contents of each bank are filled with a distinct byte for identification.
+func rom(header []byte, trainer int, prg int, chr int) []byte {
+	b := append([]byte{}, header...)
+	b = append(b, bytes.Repeat([]byte{0x7e}, trainer)...)
+	b = append(b, bytes.Repeat([]byte{0xa0}, prg)...)
+	b = append(b, bytes.Repeat([]byte{0xc0}, chr)...)
+	return b
+}
+
+func TestLoadINES(t *testing.T) {
+	// Super Mario Bros.: 32 kB PRG, 8 kB CHR, mapper 0, vertical
+	h := []byte{'N', 'E', 'S', 0x1a, 2, 1, 0b0000_0001, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	cart, err := Load(bytes.NewReader(rom(h, 0, 32*1024, 8*1024)))
+	assert.NoError(t, err)
+	assert.False(t, cart.Nes2)
+	assert.Equal(t, cart.PrgRomSize, 32*1024)
+	assert.Equal(t, cart.ChrRomSize, 8*1024)
+	assert.Equal(t, cart.PrgRamSize, 8*1024)
+	assert.Equal(t, cart.ChrRamSize, 0)
+	assert.Equal(t, cart.Mapper, uint16(0))
+	assert.Equal(t, cart.Mirroring, Vertical)
+	assert.Equal(t, cart.Region, NTSC)
+	assert.Len(t, cart.PrgRom, 32*1024)
+	assert.Len(t, cart.ChrRom, 8*1024)
+	assert.Equal(t, cart.PrgRom[0], byte(0xa0))
+	assert.Equal(t, cart.ChrRom[0], byte(0xc0))
+
+	// mapper 4 (0x4_), battery, trainer, no CHR-ROM, PAL
+	h = []byte{'N', 'E', 'S', 0x1a, 8, 0, 0b0100_0110, 0b0000_0000, 1, 1, 0, 0, 0, 0, 0, 0}
+	cart, err = Load(bytes.NewReader(rom(h, 512, 128*1024, 0)))
+	assert.NoError(t, err)
+	assert.Equal(t, cart.Mapper, uint16(4))
+	assert.True(t, cart.Battery)
+	assert.True(t, cart.Trainer)
+	assert.Equal(t, cart.Mirroring, Horizontal)
+	assert.Equal(t, cart.Region, PAL)
+	assert.Equal(t, cart.PrgNvramSize, 8*1024)
+	assert.Equal(t, cart.ChrRamSize, 8*1024)
+	assert.Len(t, cart.TrainerData, 512)
+	assert.Equal(t, cart.TrainerData[0], byte(0x7e))
+	assert.Equal(t, cart.PrgRom[0], byte(0xa0))
+	assert.Empty(t, cart.ChrRom)
+
+	// "DiskDude!" in bytes 7-15 invalidates the upper mapper nibble
+	h = append([]byte{'N', 'E', 'S', 0x1a, 1, 1, 0b0001_1000}, "DiskDude!"...)
+	cart, err = Load(bytes.NewReader(rom(h, 0, 16*1024, 8*1024)))
+	assert.NoError(t, err)
+	assert.Equal(t, cart.Mapper, uint16(1))
+	assert.Equal(t, cart.Mirroring, FourScreen)
+}
+
+func TestLoadNes2(t *testing.T) {
+	h := []byte{
+		'N', 'E', 'S', 0x1a,
+		0x02, 0x00, // PRG: 0x102 * 16 kB; CHR: 0
+		0b1010_0011, // mapper low nibble 0xa, battery, vertical
+		0b0011_1000, // mapper mid nibble 0x3, NES 2.0
+		0b0101_0001, // submapper 5, mapper high nibble 0x1
+		0x01,        // PRG MSB 1
+		0x70,        // 8 kB PRG-NVRAM
+		0x07,        // 8 kB CHR-RAM
+		0x03,        // Dendy
+		0, 0, 0,
+	}
+	hdr, err := ParseHeader(h)
+	assert.NoError(t, err)
+	assert.True(t, hdr.Nes2)
+	assert.Equal(t, hdr.Mapper, uint16(0x13a))
+	assert.Equal(t, hdr.Submapper, byte(5))
+	assert.Equal(t, hdr.PrgRomSize, 0x102*16*1024)
+	assert.Equal(t, hdr.ChrRomSize, 0)
+	assert.Equal(t, hdr.PrgRamSize, 0)
+	assert.Equal(t, hdr.PrgNvramSize, 8*1024)
+	assert.Equal(t, hdr.ChrRamSize, 8*1024)
+	assert.Equal(t, hdr.ChrNvramSize, 0)
+	assert.Equal(t, hdr.Mirroring, Vertical)
+	assert.Equal(t, hdr.Region, Dendy)
+
+	// exponent-multiplier notation: 2^4 * (1*2+1) = 48 bytes
+	h[4] = 0b0001_0001
+	h[9] = 0x0f
+	hdr, err = ParseHeader(h)
+	assert.NoError(t, err)
+	assert.Equal(t, hdr.PrgRomSize, 48)
+
+	// 2^30 * 7 bytes would not fit in any cartridge
+	h[4] = 0b0111_1011
+	_, err = ParseHeader(h)
+	assert.EqualError(t, err, "PRG-ROM: size 2^30*7 is too large")
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(bytes.NewReader([]byte("NES\x1a")))
+	assert.ErrorContains(t, err, "failed to read header")
+
+	h := []byte{'N', 'E', 'S', 0x1b, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	_, err = Load(bytes.NewReader(rom(h, 0, 32*1024, 8*1024)))
+	assert.ErrorContains(t, err, "bad magic")
+
+	h[3] = 0x1a
+	_, err = Load(bytes.NewReader(rom(h, 0, 32*1024, 4*1024)))
+	assert.EqualError(t, err, "header declares 8192 bytes of CHR-ROM, but only 4096 remain")
+
+	// the largest plain size, with nothing to back it
+	h[4], h[9] = 0xff, 0x0e
+	h[7] = 0b0000_1000 // NES 2.0
+	_, err = Load(bytes.NewReader(rom(h, 0, 16*1024, 0)))
+	assert.EqualError(t, err, "header declares 62898176 bytes of PRG-ROM, but only 16384 remain")
+
+	h[4], h[9] = 0, 0
+	_, err = Load(bytes.NewReader(rom(h, 0, 0, 8*1024)))
+	assert.EqualError(t, err, "header declares no PRG-ROM")
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"gone/apu"
+	"gone/cartridge"
+	"gone/cpu"
+	"gone/mapper"
+	"gone/mem"
+	"gone/nes"
+	"gone/terminal"
+)
+
+func runFlags(fs *flag.FlagSet, o *options) {
+	addRegion(fs, o)
+	addLog(fs, o)
+	fs.Float64Var(&o.scale, "scale", 0, "size of the picture, relative to 256x240 (0: fit the terminal)")
+	fs.BoolVar(&o.headless, "headless", false, "do not display anything (e.g. to take a -screenshot)")
+	fs.IntVar(&o.frames, "frames", 0, "stop after this many frames (0: run until interrupted)")
+	fs.StringVar(&o.screenshot, "screenshot", "", "on exit, save the last frame to this PNG file")
+	fs.StringVar(&o.wav, "wav", "", "record the audio to this WAV file (mono, 44.1 kHz)")
+}
+
+func debugFlags(fs *flag.FlagSet, o *options) {
+	addRegion(fs, o)
+	addLog(fs, o)
+}
+
+func testFlags(fs *flag.FlagSet, o *options) {
+	addRegion(fs, o)
+	addLog(fs, o)
+	fs.IntVar(&o.frames, "frames", 60*60, "give up after this many frames")
+}
+
+// parseRegion parses the -region flag. auto is true if the region of the
+// cartridge should be used.
+func parseRegion(s string) (r cartridge.Region, auto bool, err error) {
+	switch strings.ToLower(s) {
+	case "auto", "":
+		return 0, true, nil
+	case "ntsc":
+		return cartridge.NTSC, false, nil
+	case "pal":
+		return cartridge.PAL, false, nil
+	case "dendy":
+		return cartridge.Dendy, false, nil
+	}
+	return 0, false, fmt.Errorf("unknown region: %q", s)
+}
+
+// openConsole loads the ROM at path, and applies the options common to all
+// commands that run it.
+func openConsole(path string, o *options) (*nes.Console, error) {
+	region, auto, err := parseRegion(o.region)
+	if err != nil {
+		return nil, err
+	}
+	c, err := nes.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !auto {
+		c.SetRegion(region)
+	}
+	return c, nil
+}
+
+// openLog redirects the standard logger to the -log file, since the terminal
+// is usually busy.
+func openLog(o *options) (func(), error) {
+	f, err := os.OpenFile(o.log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	log.SetOutput(f)
+	return func() { f.Close() }, nil
+}
+
+var errDone = errors.New("done")
+
+func runRom(o *options, args []string) (err error) {
+	c, err := openConsole(args[0], o)
+	if err != nil {
+		return err
+	}
+	closeLog, err := openLog(o)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	record := func(done bool) error { return nil }
+	if o.wav != "" {
+		if record, err = startRecording(c, o.wav); err != nil {
+			return err
+		}
+		// the file must be completed however the run ends
+		defer func() {
+			if rerr := record(true); err == nil {
+				err = rerr
+			}
+		}()
+	}
+
+	frames := 0
+	onFrame := func(*nes.Console) error {
+		if err := record(false); err != nil {
+			return err
+		}
+		frames++
+		if frames == o.frames {
+			return errDone
+		}
+		return nil
+	}
+	if o.headless {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		err = nes.NewRunner(c).Run(ctx, onFrame)
+	} else {
+		err = terminal.Play(nes.NewRunner(c), o.scale, onFrame)
+	}
+	if err != nil && !errors.Is(err, errDone) && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	if o.screenshot != "" {
+		return screenshot(c, o.screenshot, o.scale)
+	}
+	return nil
+}
+
+const sampleRate = 44100
+
+// startRecording sends the audio of c to a WAV file. The returned func writes
+// the samples produced so far; it must be called regularly (e.g. every
+// frame), then once more with done set, which completes the file.
+func startRecording(c *nes.Console, path string) (func(done bool) error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := apu.NewWavWriter(f, sampleRate)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.Audio = apu.NewResampler(c.CpuRate(), sampleRate)
+
+	return func(done bool) error {
+		err := w.Write(c.Audio.Samples)
+		c.Audio.Samples = c.Audio.Samples[:0]
+		if !done {
+			return err
+		}
+		// the file is closed even if a write failed
+		if err == nil {
+			err = w.Close()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+// screenshot saves the last frame of c, scaled (by nearest neighbour) by
+// scale (or 1, if 0).
+func screenshot(c *nes.Console, path string, scale float64) error {
+	if scale <= 0 {
+		scale = 1
+	}
+	src := c.Ppu.Image()
+	w := int(float64(src.Bounds().Dx()) * scale)
+	h := int(float64(src.Bounds().Dy()) * scale)
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	for y := range dst.Bounds().Dy() {
+		for x := range dst.Bounds().Dx() {
+			dst.Set(x, y, src.At(int(float64(x)/scale), int(float64(y)/scale)))
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, dst); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// debug opens either a cartridge, or a text file of hex bytes (e.g. "A9 01
+// 8D 00 02"), which is loaded at 0x8000.
+func debug(o *options, args []string) error {
+	cpu.DebugLog = o.log
+
+	path := args[0]
+	program, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(program, []byte("NES\x1a")) {
+		c, err := openConsole(path, o)
+		if err != nil {
+			return err
+		}
+		// the debugger executes one instruction at a time; the
+		// cycle-accurate mode clocks everything else along with it
+		c.SetCycleAccurate(true)
+		return c.Cpu.Debug(nil, c.Cpu.ProgramCounter&0xfff0)
+	}
+
+	c := &cpu.Cpu{Bus: &mem.Bus{}}
+	return c.Debug(program, 0x8000)
+}
+
+// disasm disassembles the PRG-ROM in banks of 16 kB. Since the mapping of
+// banks depends on the mapper, each bank is assumed to be at 0x8000, except
+// for the last, which is usually fixed at 0xc000 (and contains the vectors).
+func disasm(o *options, args []string) error {
+	cart, err := cartridge.Open(args[0])
+	if err != nil {
+		return err
+	}
+	const bankSize = 16 * 1024
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	t := cpu.NewTable(cpu.Ricoh2A03)
+	nBanks := (len(cart.PrgRom) + bankSize - 1) / bankSize
+	for bank := range nBanks {
+		data := cart.PrgRom[bank*bankSize : min((bank+1)*bankSize, len(cart.PrgRom))]
+		base := uint16(0x8000)
+		if bank == nBanks-1 {
+			base = 0xc000
+		}
+		read := func(addr uint16) byte {
+			if i := int(addr - base); i < len(data) {
+				return data[i]
+			}
+			return 0
+		}
+
+		fmt.Fprintf(w, "; bank %d\n", bank)
+		for i := 0; i < len(data); {
+			addr := base + uint16(i)
+			s, n := t.Disassemble(read, addr)
+			var raw []string
+			for j := range n {
+				raw = append(raw, fmt.Sprintf("%02X", read(addr+uint16(j))))
+			}
+			fmt.Fprintf(w, "%04X  %-8s  %s\n", addr, strings.Join(raw, " "), s)
+			i += n
+		}
+	}
+	return nil
+}
+
+func info(o *options, args []string) error {
+	cart, err := cartridge.Open(args[0])
+	if err != nil {
+		return err
+	}
+	h := cart.Header
+
+	format := "iNES"
+	if h.Nes2 {
+		format = "NES 2.0"
+	}
+	supported := "supported"
+	if _, err := mapper.New(cart); err != nil {
+		supported = "unsupported"
+	}
+	kb := func(n int) string {
+		if n == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d kB", n/1024)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "format\t%s\n", format)
+	fmt.Fprintf(w, "mapper\t%d (%s)\n", h.Mapper, supported)
+	if h.Nes2 {
+		fmt.Fprintf(w, "submapper\t%d\n", h.Submapper)
+	}
+	fmt.Fprintf(w, "PRG-ROM\t%s\n", kb(h.PrgRomSize))
+	fmt.Fprintf(w, "CHR-ROM\t%s\n", kb(h.ChrRomSize))
+	fmt.Fprintf(w, "PRG-RAM\t%s\n", kb(h.PrgRamSize))
+	fmt.Fprintf(w, "PRG-NVRAM\t%s\n", kb(h.PrgNvramSize))
+	fmt.Fprintf(w, "CHR-RAM\t%s\n", kb(h.ChrRamSize))
+	fmt.Fprintf(w, "CHR-NVRAM\t%s\n", kb(h.ChrNvramSize))
+	fmt.Fprintf(w, "mirroring\t%s\n", h.Mirroring)
+	fmt.Fprintf(w, "battery\t%t\n", h.Battery)
+	fmt.Fprintf(w, "trainer\t%t\n", h.Trainer)
+	fmt.Fprintf(w, "region\t%s\n", h.Region)
+	return w.Flush()
+}
+
+func testRom(o *options, args []string) error {
+	c, err := openConsole(args[0], o)
+	if err != nil {
+		return err
+	}
+	closeLog, err := openLog(o)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	res, err := c.RunTest(o.frames)
+	if text := strings.TrimSpace(res.Text); text != "" {
+		fmt.Println(text)
+	}
+	switch {
+	case err != nil:
+		return err
+	case !res.Passed():
+		return fmt.Errorf("failed (status %d)", res.Status)
+	}
+	fmt.Println("passed")
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package controller implements the input devices that are plugged into the
+// 2 controller ports of the NES.
+//
+// The Cpu talks to the ports via 2 registers: writing to 0x4016 sets the
+// output lines of both ports (in practice, only the 'strobe' bit is used),
+// and reading 0x4016 or 0x4017 returns the data lines of port 1 or 2
+// respectively. Most devices are read serially, one bit per read.
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// https://www.nesdev.org/wiki/Input_devices
+// https://www.nesdev.org/wiki/Controller_reading
+// https://www.nesdev.org/wiki/Standard_controller
+
+const (
+	JOY1 = 0x4016 // read/write
+	JOY2 = 0x4017 // read
+)
+
+// A Port is a device that can be plugged into a controller port.
+type Port interface {
+	// Write receives every byte written to 0x4016. Bit 0 is the strobe.
+	Write(data byte)
+
+	// Read returns the state of the data lines (D0-D4) of the port. Like
+	// mem.Device, if readonly is true, the device must not change state
+	// (e.g. shift out its next bit).
+	Read(readonly bool) byte
+}
+
+// Ports is a mem.Device that connects up to 2 Ports to the Bus (0x4016-0x4017).
+// Since 0x4017 is also an APU register (for writes), Ports is typically
+// connected via the Apu, rather than registered directly.
+type Ports struct {
+	Port1 Port // nil if nothing is plugged in
+	Port2 Port
+}
+
+func (p *Ports) Range() (uint16, uint16) { return JOY1, JOY2 }
+
+// Read returns the data lines of the given port. The upper 3 bits are not
+// driven, and hold open bus, which is usually the high byte of the address
+// (0x40). Some games rely on this, e.g. by comparing against 0x41.
+func (p *Ports) Read(addr uint16, readonly bool) byte {
+	port := p.Port1
+	if addr == JOY2 {
+		port = p.Port2
+	}
+	data := byte(addr>>8) & 0xe0
+	if port != nil {
+		data |= port.Read(readonly) & 0x1f
+	}
+	return data
+}
+
+// Write passes data to both Ports; writes to 0x4017 are ignored.
+func (p *Ports) Write(addr uint16, data byte) {
+	if addr != JOY1 {
+		return
+	}
+	for _, port := range []Port{p.Port1, p.Port2} {
+		if port != nil {
+			port.Write(data)
+		}
+	}
+}
+
+// Buttons holds the state of the 8 buttons of a standard controller, 1 bit
+// per button. The bits are in the order in which they are reported.
+type Buttons byte
+
+const (
+	A Buttons = 1 << iota
+	B
+	Select
+	Start
+	Up
+	Down
+	Left
+	Right
+)
+
+var buttonNames = [8]string{"A", "B", "Select", "Start", "Up", "Down", "Left", "Right"}
+
+// String returns the pressed buttons, joined by '+', e.g. "A+Start".
+func (b Buttons) String() string {
+	var names []string
+	for i, name := range buttonNames {
+		if b&(1<<i) > 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// ParseButtons is the inverse of Buttons.String. Names are case insensitive.
+// This is mostly useful for scripting input.
+func ParseButtons(s string) (Buttons, error) {
+	var b Buttons
+	if s == "" {
+		return b, nil
+	}
+outer:
+	for _, name := range strings.Split(s, "+") {
+		for i, n := range buttonNames {
+			if strings.EqualFold(strings.TrimSpace(name), n) {
+				b |= 1 << i
+				continue outer
+			}
+		}
+		return 0, fmt.Errorf("unknown button: %q", name)
+	}
+	return b, nil
+}
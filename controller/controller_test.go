@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gone/mem"
+	"gone/ppu"
+)
+
+func TestController(t *testing.T) {
+	c := &Controller{Buttons: A | Start | Right}
+	p := &Ports{Port1: c}
+
+	// strobe
+	p.Write(JOY1, 1)
+	assert.Equal(t, p.Read(JOY1, false), byte(0x41))
+	assert.Equal(t, p.Read(JOY1, false), byte(0x41))
+	p.Write(JOY1, 0)
+
+	// changes after the strobe are not seen until the next strobe
+	c.Buttons = 0
+
+	var bits []byte
+	for range 10 {
+		bits = append(bits, p.Read(JOY1, false))
+	}
+	assert.Equal(t, bits, []byte{
+		0x41, 0x40, 0x40, 0x41, 0x40, 0x40, 0x40, 0x41,
+		0x41, 0x41, // after 8 reads
+	})
+
+	// peeking doesn't shift
+	p.Write(JOY1, 1)
+	c.Buttons = B
+	p.Write(JOY1, 0)
+	assert.Equal(t, p.Read(JOY1, true), byte(0x40))
+	assert.Equal(t, p.Read(JOY1, true), byte(0x40))
+	assert.Equal(t, p.Read(JOY1, false), byte(0x40))
+	assert.Equal(t, p.Read(JOY1, false), byte(0x41))
+}
+
+func TestPorts(t *testing.T) {
+	c1, c2 := &Controller{Buttons: A}, &Controller{Buttons: B}
+	p := &Ports{Port1: c1, Port2: c2}
+
+	// both ports are strobed together
+	p.Write(JOY1, 1)
+	p.Write(JOY1, 0)
+	assert.Equal(t, p.Read(JOY1, false), byte(0x41))
+	assert.Equal(t, p.Read(JOY2, false), byte(0x40))
+	assert.Equal(t, p.Read(JOY2, false), byte(0x41))
+
+	// empty ports only return open bus
+	p.Port2 = nil
+	assert.Equal(t, p.Read(JOY2, false), byte(0x40))
+}
+
+func TestParseButtons(t *testing.T) {
+	b, err := ParseButtons("a+START + left")
+	assert.NoError(t, err)
+	assert.Equal(t, b, A|Start|Left)
+	assert.Equal(t, b.String(), "A+Start+Left")
+
+	b, err = ParseButtons("")
+	assert.NoError(t, err)
+	assert.Equal(t, b, Buttons(0))
+
+	_, err = ParseButtons("A+Turbo")
+	assert.EqualError(t, err, `unknown button: "Turbo"`)
+}
+
+func TestFourScore(t *testing.T) {
+	f := &FourScore{Buttons: [4]Buttons{A, B, Select, Start}}
+	p := &Ports{Port1: f.Port1(), Port2: f.Port2()}
+
+	p.Write(JOY1, 1)
+	p.Write(JOY1, 0)
+	var port1, port2 uint32
+	for i := range 24 {
+		port1 |= uint32(p.Read(JOY1, false)&1) << i
+		port2 |= uint32(p.Read(JOY2, false)&1) << i
+	}
+	assert.Equal(t, port1, uint32(0x10_04_01))
+	assert.Equal(t, port2, uint32(0x20_08_02))
+	assert.Equal(t, p.Read(JOY1, false), byte(0x41))
+}
+
+func TestZapper(t *testing.T) {
+	screen := &ppu.Ppu{Bus: &mem.PpuBus{}}
+	// rendering is disabled, so the whole screen is the (white) backdrop
+	screen.Bus.Write(0x3f00, 0x30)
+
+	z := &Zapper{Ppu: screen, X: 100, Y: 50}
+	p := &Ports{Port2: z}
+	assert.Equal(t, p.Read(JOY2, false), byte(0x48)) // no light
+
+	for screen.Scanline != 50 || screen.Dot != 101 {
+		screen.Step()
+	}
+	assert.Equal(t, p.Read(JOY2, false), byte(0x48)) // not drawn yet
+	screen.Step()
+	assert.Equal(t, p.Read(JOY2, false), byte(0x40))
+
+	// the sensor turns off after a while
+	for screen.Scanline != 50+zapperLines {
+		screen.Step()
+	}
+	assert.Equal(t, p.Read(JOY2, false), byte(0x48))
+
+	z.Trigger = true
+	z.X = -1
+	assert.Equal(t, p.Read(JOY2, false), byte(0x58))
+}
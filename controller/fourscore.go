@@ -0,0 +1,68 @@
+package controller
+
+// https://www.nesdev.org/wiki/Four_Score
+
+// A FourScore is an adapter that occupies both ports, allowing 4 standard
+// controllers to be connected. Each port reports 24 bits: the buttons of 2
+// controllers (1 and 3 on port 1, 2 and 4 on port 2), followed by a signature
+// that identifies the adapter.
+//
+// Connect it with Ports{Port1: f.Port1(), Port2: f.Port2()}.
+type FourScore struct {
+	// Buttons holds the state of the 4 controllers, which may be set at
+	// any time by the frontend.
+	Buttons [4]Buttons
+
+	ports [2]fourScorePort
+}
+
+// signatures are the 3rd byte reported by each port.
+var signatures = [2]byte{0x10, 0x20}
+
+// Port1 returns the half of f that is plugged into port 1 (controllers 1 and
+// 3).
+func (f *FourScore) Port1() Port { return f.port(0) }
+
+// Port2 returns the half of f that is plugged into port 2 (controllers 2 and
+// 4).
+func (f *FourScore) Port2() Port { return f.port(1) }
+
+func (f *FourScore) port(n int) Port {
+	p := &f.ports[n]
+	p.f, p.n = f, n
+	return p
+}
+
+type fourScorePort struct {
+	f      *FourScore
+	n      int // 0 or 1
+	strobe bool
+	shift  uint32 // 24 bits
+	reads  int
+}
+
+func (p *fourScorePort) Write(data byte) {
+	strobe := data&1 > 0
+	if p.strobe && !strobe {
+		p.shift = uint32(p.f.Buttons[p.n]) |
+			uint32(p.f.Buttons[p.n+2])<<8 |
+			uint32(signatures[p.n])<<16
+		p.reads = 0
+	}
+	p.strobe = strobe
+}
+
+func (p *fourScorePort) Read(readonly bool) byte {
+	switch {
+	case p.strobe:
+		return byte(p.f.Buttons[p.n] & A)
+	case p.reads >= 24:
+		return 1
+	}
+	data := byte(p.shift & 1)
+	if !readonly {
+		p.shift >>= 1
+		p.reads++
+	}
+	return data
+}
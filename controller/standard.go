@@ -0,0 +1,43 @@
+package controller
+
+// A Controller is the standard NES controller (joypad).
+//
+// While the strobe is high, the controller continuously reloads its 8-bit
+// shift register from the buttons, so every read returns the state of A.
+// Once the strobe goes low, each read shifts out the next button, in the
+// order A, B, Select, Start, Up, Down, Left, Right. After all 8 buttons have
+// been read, further reads return 1.
+type Controller struct {
+	// Buttons is the current state of the buttons; it may be set at any
+	// time (typically once per frame) by the frontend.
+	Buttons Buttons
+
+	strobe bool
+	shift  byte
+	reads  int // since the strobe went low
+}
+
+func (c *Controller) Write(data byte) {
+	strobe := data&1 > 0
+	if c.strobe && !strobe {
+		// the buttons are latched when the strobe goes low
+		c.shift = byte(c.Buttons)
+		c.reads = 0
+	}
+	c.strobe = strobe
+}
+
+func (c *Controller) Read(readonly bool) byte {
+	switch {
+	case c.strobe:
+		return byte(c.Buttons & A)
+	case c.reads >= 8:
+		return 1
+	}
+	data := c.shift & 1
+	if !readonly {
+		c.shift >>= 1
+		c.reads++
+	}
+	return data
+}
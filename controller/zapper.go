@@ -0,0 +1,62 @@
+package controller
+
+import "gone/ppu"
+
+// https://www.nesdev.org/wiki/Zapper
+
+const (
+	zapperLight   = 1 << 3 // 0: light detected
+	zapperTrigger = 1 << 4 // 1: pulled
+)
+
+// zapperLines is the number of scanlines for which the light sensor stays on
+// after the beam has passed a bright pixel.
+const zapperLines = 26
+
+// zapperBrightness is the minimum luma (0-255) that the light sensor detects.
+const zapperBrightness = 0xa0
+
+// A Zapper is the NES light gun, typically plugged into port 2.
+//
+// The Zapper does not know where it is pointed; instead, a photodiode detects
+// whether the screen is bright at the point of aim. Since CRTs only light up
+// where the beam has just passed, games draw bright targets and then poll
+// the sensor while the frame is being rendered. Here, the sensor sees the
+// pixel at X, Y of the frame being drawn by the Ppu, for a few scanlines
+// after the beam has drawn it.
+type Zapper struct {
+	Ppu *ppu.Ppu
+
+	// X and Y are the point of aim, in pixels. Negative values mean that
+	// the Zapper is pointed away from the screen.
+	X, Y    int
+	Trigger bool
+}
+
+// light reports whether the sensor detects light.
+func (z *Zapper) light() bool {
+	if z.Ppu == nil || z.X < 0 || z.Y < 0 || z.X >= ppu.Width || z.Y >= ppu.Height {
+		return false
+	}
+	lines := z.Ppu.Scanline - z.Y
+	if lines < 0 || lines >= zapperLines || (lines == 0 && z.Ppu.Dot <= z.X+1) {
+		return false
+	}
+	c := ppu.Palette[z.Ppu.Pixel(z.X, z.Y)&0x3f]
+	luma := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+	return luma >= zapperBrightness
+}
+
+// Write is ignored; the Zapper has no strobe.
+func (z *Zapper) Write(data byte) {}
+
+func (z *Zapper) Read(readonly bool) byte {
+	var data byte
+	if !z.light() {
+		data |= zapperLight
+	}
+	if z.Trigger {
+		data |= zapperTrigger
+	}
+	return data
+}
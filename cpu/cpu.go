@@ -1,5 +1,5 @@
 // Package cpu implements the MOS Technology 6502 microprocessor, as used in
-// the NES.
+// the NES. A few other members of the 6502 family are available as a Variant.
 
 package cpu
 
@@ -9,7 +9,6 @@ import (
 	"log"
 	"strconv"
 	"strings"
-	"time"
 
 	"gone/mask"
 	"gone/mem"
@@ -17,14 +16,9 @@ import (
 
 // https://www.nesdev.org/wiki/CPU#Frequencies
 // https://www.nesdev.org/wiki/Cycle_reference_chart#Clock_rates
-
-// CPU_TICK  = time.Nanosecond * 5590
-// CPU_TICK2 = time.Duration(time.Second).Nanoseconds() / 1789773
-
-var (
-	tick = 10e9 / 1789773 // cannot be inlined into time.Duration, even with cast
-	Tick = time.Nanosecond * time.Duration(tick)
-)
+//
+// The Cpu has no notion of time; the clock rate depends on the region, and is
+// decided by whoever calls Clock (see nes.Console).
 
 // The Cpu has no memory of its own (aside from a number of small registers
 // which amount to about 7 bytes). Instead, the Cpu interfaces with a Bus that
@@ -51,7 +45,7 @@ type Cpu struct {
 		Zero             bool // bit 1
 		Carry            bool // bit 0
 		B                bool // bit 4; unused
-		Decimal          bool // bit 3; inherited from 6502, but unused by NES
+		Decimal          bool // bit 3; inherited from 6502, but unused by NES (see Variant)
 		// note: if numeric indexing is required, switch to `Flags byte`
 	}
 
@@ -79,16 +73,79 @@ type Cpu struct {
 	M          byte   // data that is set after Cpu.decode
 	Cycles     byte   // decrements to 0, at which point a new instruction is executed
 
+	// TotalCycles counts the cycles elapsed since power-on, including
+	// stalls.
+	TotalCycles uint64
+
+	// OnCycle, if not nil, enables the cycle-accurate mode. Instead of
+	// performing all the work of an instruction at once, then waiting,
+	// every cycle is one bus access (including the dummy reads and writes
+	// of real hardware), and OnCycle is called before each of them. The
+	// caller clocks the other components (Ppu, Apu) in OnCycle, so that
+	// they observe the accesses on the correct cycle.
+	//
+	// https://www.nesdev.org/6502_cpu.txt
+	OnCycle func()
+
+	// Jam decides what happens when a KIL opcode is executed. By default,
+	// the Cpu halts, like on hardware.
+	Jam JamPolicy
+
+	// Illegal decides what happens when an Unofficial opcode is fetched.
+	// By default, it is executed like any other.
+	Illegal IllegalPolicy
+
+	// Variant is the member of the 6502 family being emulated; the default
+	// is the NES Cpu.
+	Variant Variant
+
+	// Table holds the Opcodes executed by the Cpu. If nil, it is built
+	// from Variant when the first instruction is fetched; to change the
+	// Variant after that, set Table to nil. A Table may also be patched,
+	// e.g. to instrument an Instruction, without affecting other Cpus.
+	Table *Table
+
+	// https://www.nesdev.org/wiki/CPU_interrupts
+
+	nmiLine    bool      // the level of the NMI input, for edge detection
+	nmiPending bool      // set on a rising edge of NMI, cleared once serviced
+	irqLines   IrqSource // the sources holding the IRQ input
+
+	// interrupts are polled on the last cycle of each instruction (see
+	// poll); the result decides what happens instead of the next
+	// instruction
+	doNmi, doIrq bool
+	// DisableInterrupt as of the last cycle of the current instruction,
+	// which is not always the same as the flag itself (see execute)
+	disabled bool
+	// the current 'instruction' is an interrupt sequence, which does not
+	// poll for interrupts
+	inInterrupt bool
+	// the value of Cycles at which Clock polls, i.e. the number of cycles
+	// of the instruction left after the poll. usually 0 (see branch)
+	pollCycles byte
+
+	stall  int  // cycles during which the Cpu is suspended; see Stall
+	halted bool // jammed by KIL; only Reset recovers
+
 	// PageCrossed bool // if true AND branch succeeded, add 1 extra cycle to current instruction
 	// Opcode     Opcode // current opcode (not really necessary? maybe for interrupt purposes)
 	// RelAddress  int8 // relative to current PC, used exclusively in brancing instructions (probably not needed?)
 }
 
 // Read reads one byte from the given addr. The addr is typically supplied by
-// the program.
+// the program. Like on real hardware, the read may have side effects on the
+// Device at addr.
 func (c *Cpu) Read(addr uint16) byte {
 	// note: we usually return byte, but Cpu typically has to cast
 	// ('concats') bytes into uint16 to form mem addresses
+	return c.Bus.Read(addr, false)
+}
+
+// Peek reads one byte from the given addr without any side effects. This is
+// never used during execution; it exists so that memory can be inspected
+// without changing the state of the emulation.
+func (c *Cpu) Peek(addr uint16) byte {
 	return c.Bus.Read(addr, true)
 }
 
@@ -100,19 +157,64 @@ func (c *Cpu) Write(
 	c.Bus.Write(addr, data)
 }
 
-// LoadProgram reads a slice of bytes and places it at the given addr.
+// read is a bus access performed by the Cpu itself, as part of an instruction.
+// In cycle-accurate mode, each one takes a cycle.
+func (c *Cpu) read(addr uint16) byte {
+	c.cycle()
+	return c.Bus.Read(addr, false)
+}
+
+func (c *Cpu) write(addr uint16, data byte) {
+	c.cycle()
+	c.Bus.Write(addr, data)
+}
+
+// dummyRead performs a read whose result is discarded. Such reads happen on
+// real hardware whenever the Cpu has nothing better to do with the bus, and
+// may have side effects (e.g. acknowledging an interrupt, or advancing a
+// controller). They are only performed in cycle-accurate mode.
+func (c *Cpu) dummyRead(addr uint16) {
+	if c.OnCycle != nil {
+		c.read(addr)
+	}
+}
+
+// dummyWrite performs a write of the unmodified byte, which read-modify-write
+// instructions do while computing the result. It is only performed in
+// cycle-accurate mode.
+func (c *Cpu) dummyWrite(addr uint16, data byte) {
+	if c.OnCycle != nil {
+		c.write(addr, data)
+	}
+}
+
+// cycle runs OnCycle, if any. Interrupts are polled at the start of every
+// cycle, so that the last poll of an instruction sees the interrupt lines as
+// they were at the end of its penultimate cycle.
+func (c *Cpu) cycle() {
+	if c.OnCycle != nil {
+		if !c.inInterrupt {
+			c.poll(c.Flags.DisableInterrupt)
+		}
+		c.TotalCycles++
+		c.OnCycle()
+	}
+}
+
+// LoadProgram reads a slice of bytes and places it at the given addr. The
+// region of the Bus containing addr must be writable (e.g. a mem.Memory).
 func (c *Cpu) LoadProgram(program []byte, addr uint16) {
 	for i, s := range strings.Fields(string(program)) {
 		b, err := strconv.ParseInt(s, 16, 16)
 		if err != nil {
 			panic(err)
 		}
-		c.Bus.FakeRam[addr+uint16(i)] = byte(b)
+		c.Write(addr+uint16(i), byte(b))
 	}
 }
 
 // An AddressingMode tells the Cpu where to access (look for) a given byte of
-// memory. There are 13 possible modes.
+// memory. There are 13 possible modes (15 on the 65C02).
 //
 // Most Instructions can index the full 64 kB range of memory, that is, 256
 // pages of 256 bytes. The exception is ZeroPage, which is confined to the
@@ -153,8 +255,25 @@ const (
 	// 2 increments, 4 reads
 
 	Indirect // JMP
+
+	// 65C02 only
+
+	ZeroPageIndirect  // (zp); like IndirectY, without Y
+	AbsoluteIndirectX // JMP (abs,X)
 )
 
+// Operands returns the number of bytes that follow an opcode with the
+// AddressingMode (0 to 2).
+func (a AddressingMode) Operands() int {
+	switch a {
+	case Implied, Accumulator:
+		return 0
+	case Absolute, AbsoluteX, AbsoluteY, Indirect, AbsoluteIndirectX:
+		return 2
+	}
+	return 1
+}
+
 // func checkByteAddr(b uint16) {
 // 	if b < 0 || b > 0xffff {
 // 		panic(1024)
@@ -178,13 +297,73 @@ const (
 // 	Negative
 // )
 
+// An OpcodeError is returned when the Cpu cannot (or, depending on Jam, will
+// not) execute the Opcode found at PC.
+type OpcodeError struct {
+	PC     uint16
+	Opcode byte
+	Name   string // as found in the Table of the Cpu; empty if undefined
+}
+
+func (e *OpcodeError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("illegal opcode %02x (%s) at %04x", e.Opcode, name, e.PC)
+}
+
+// A JamPolicy decides what the Cpu does when it executes one of the 12 KIL
+// opcodes. Test ROMs sometimes use KIL deliberately, to stop execution; in
+// games, it usually means that the program has gone off the rails.
+type JamPolicy int
+
+const (
+	// JamHalt stops the Cpu, like on hardware. Clock keeps counting
+	// cycles, but nothing is executed, and interrupts are ignored, until
+	// Reset.
+	JamHalt JamPolicy = iota
+	// JamError halts the Cpu, and returns an *OpcodeError from Clock.
+	JamError
+	// JamTrace logs the state of the Cpu, then continues as if KIL were a
+	// 1-byte NOP.
+	JamTrace
+)
+
+// An IllegalPolicy decides what the Cpu does with Unofficial opcodes. While
+// some games use them deliberately, executing one is more often a sign that
+// the program has jumped into data; the stricter policies help to catch this.
+type IllegalPolicy int
+
+const (
+	// IllegalExecute executes Unofficial opcodes like official ones.
+	IllegalExecute IllegalPolicy = iota
+	// IllegalError (strict) refuses to execute Unofficial opcodes, and
+	// returns an *OpcodeError from Clock. The ProgramCounter is left on
+	// the opcode.
+	IllegalError
+	// IllegalLog logs Unofficial opcodes, then executes them.
+	IllegalLog
+	// IllegalNop skips Unofficial opcodes (and their operands) without
+	// accessing memory, i.e. they are treated as NOPs of the correct
+	// length. They take as many cycles as the opcode would (ignoring
+	// page crosses).
+	IllegalNop
+)
+
+// Halted reports whether the Cpu has been halted by a KIL opcode.
+func (c *Cpu) Halted() bool {
+	return c.halted
+}
+
 // fetch translates b (read from the program) into an Opcode. This does not
 // involve any PC or Cycles increments.
 func (c *Cpu) fetch(b byte) (Opcode, error) {
-	oc, legal := Opcodes[b]
-	if !legal {
-		// empty opcode or NOP?
-		return Opcode{}, fmt.Errorf("Illegal byte supplied: %x", b)
+	oc := c.table()[b]
+	if oc.Instruction == nil {
+		// all 256 opcodes are defined, so this only happens if the
+		// Table was patched
+		return Opcode{}, &OpcodeError{PC: c.ProgramCounter, Opcode: b}
 	}
 	return oc, nil
 }
@@ -193,18 +372,26 @@ func (c *Cpu) fetch(b byte) (Opcode, error) {
 // mode. c.ProgramCounter is incremented zero to three times.
 //
 // The retrieved byte is stored in c.M, so that it can be used by the following
-// Instruction.
+// Instruction. Depending on the access, the byte may not be needed at all
+// (stores, jumps), in which case it is not read; reads may have side effects.
 //
 // c.Cycles is incremented immediately if a page cross occurs in AbsoluteX,
-// AbsoluteY, or IndirectY mode. For Relative mode, c.Cycles is incremented
-// conditionally in the Instruction itself.
-func (c *Cpu) decode(a AddressingMode) { // {{{
+// AbsoluteY, or IndirectY mode (only for loads, and Opcodes with fastIndex;
+// other accesses always take the extra cycle). For Relative mode, c.Cycles is incremented conditionally in
+// the Instruction itself.
+func (c *Cpu) decode(op *Opcode) { // {{{
+	acc := op.access
+	fast := acc == load || op.fastIndex
 
 	// https://www.ascii-code.com/
 
-	// TODO: is a pc increment equivalent to a clock tick?
+	// every cycle is a bus access; when there is no useful access to
+	// perform, the Cpu performs a dummy read instead (usually of the next
+	// byte, or of an address that is still being computed).
+	//
+	// https://www.nesdev.org/6502_cpu.txt
 
-	switch a {
+	switch op.AddressingMode {
 
 	// using a byte in a register directly is always faster than a memory
 	// read (c.read). similarly, reading from the zero page is faster than
@@ -213,11 +400,16 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 	// 0 reads
 
 	case Implied:
-		// no byte to fetch
+		// no byte to fetch, but the next byte is read anyway (except
+		// by the 1 cycle NOPs of the 65C02, which have no time to)
+		if c.Cycles > 1 {
+			c.dummyRead(c.ProgramCounter)
+		}
 		return // 0
 
 	case Accumulator:
 		// the byte -is- the Accumulator
+		c.dummyRead(c.ProgramCounter)
 		c.M = c.Accumulator
 		return
 
@@ -231,20 +423,24 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 	// 1 read
 
 	case ZeroPage:
-		c.AbsAddress = uint16(c.Read(c.ProgramCounter))
+		c.AbsAddress = uint16(c.read(c.ProgramCounter))
 		c.ProgramCounter++
 		c.AbsAddress &= 0x00ff // clear high byte (go to page 0), keep low byte
 
 	case ZeroPageX:
 		// think struct ptr + offset. c.X is probably set by a prior
 		// instruction
-		c.AbsAddress = uint16(c.Read(c.ProgramCounter) + c.X)
+		ptr := c.read(c.ProgramCounter)
 		c.ProgramCounter++
+		c.dummyRead(uint16(ptr)) // while adding X
+		c.AbsAddress = uint16(ptr + c.X)
 		c.AbsAddress &= 0x00ff
 
 	case ZeroPageY:
-		c.AbsAddress = uint16(c.Read(c.ProgramCounter) + c.Y)
+		ptr := c.read(c.ProgramCounter)
 		c.ProgramCounter++
+		c.dummyRead(uint16(ptr))
+		c.AbsAddress = uint16(ptr + c.Y)
 		c.AbsAddress &= 0x00ff
 
 	case Relative:
@@ -254,7 +450,7 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 		// fetch a byte somewhere up to half a byte away from current
 		// absolute address (in either direction)
 
-		rel := c.Read(c.ProgramCounter)
+		rel := c.read(c.ProgramCounter)
 		c.ProgramCounter++
 		// log.Println("rel addr:", rel)
 
@@ -269,9 +465,13 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 		if rel&0x80 > 0 {
 			// important: cycle adding is deferred to the branch condition
 			c.AbsAddress -= 0x0100
-			log.Println("jumped back, destined abs addr is now:", c.AbsAddress)
+			// log.Println("jumped back, destined abs addr is now:", c.AbsAddress)
 		}
 
+		// the destination is not read (it will be fetched as an
+		// opcode)
+		return
+
 	// 2 reads
 
 	case Absolute:
@@ -282,72 +482,59 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 		// read becomes the low byte (column).
 		// https://stackoverflow.com/a/77683792
 
-		col := c.Read(c.ProgramCounter) // 0x00ff
+		col := c.read(c.ProgramCounter) // 0x00ff
 		c.ProgramCounter++
-		page := c.Read(c.ProgramCounter) // 0xff00
+		page := c.read(c.ProgramCounter) // 0xff00
 		c.ProgramCounter++
 		c.AbsAddress = mask.Word(page, col)
 		// log.Println("abs addr will be", c.AbsAddress)
 
 	case AbsoluteX:
-		col := c.Read(c.ProgramCounter)
+		col := c.read(c.ProgramCounter)
 		c.ProgramCounter++
-		page := c.Read(c.ProgramCounter)
+		page := c.read(c.ProgramCounter)
 		c.ProgramCounter++
-		c.AbsAddress = mask.Word(page, col)
-
-		c.AbsAddress += uint16(c.X)
-		if c.AbsAddress&0xff00 != uint16(page)<<8 {
-			c.Cycles++
-		}
+		c.index(mask.Word(page, col), c.X, fast)
 
 	case AbsoluteY:
-		col := c.Read(c.ProgramCounter)
+		col := c.read(c.ProgramCounter)
 		c.ProgramCounter++
-		page := c.Read(c.ProgramCounter)
+		page := c.read(c.ProgramCounter)
 		c.ProgramCounter++
-		c.AbsAddress = mask.Word(page, col)
-
-		c.AbsAddress += uint16(c.Y)
-		if c.AbsAddress&0xff00 != uint16(page)<<8 {
-			c.Cycles++
-		}
+		c.index(mask.Word(page, col), c.Y, fast)
 
 	// 3 reads
 
 	case IndirectX:
 
 		// only 1 pc increment, but 3 reads
-		ptr := c.Read(c.ProgramCounter)
+		ptr := c.read(c.ProgramCounter)
 		c.ProgramCounter++
+		c.dummyRead(uint16(ptr)) // while adding X
+
 		// we only jump once, to somewhere in page 0. once there, we
 		// read 2 adjacent bytes, with a given X offset, and concat
 		// those 2 bytes into a new word (addr), which is where we go
 		// to.
 
-		// note: we first cast into uint16 to avoid byte overflow, and
-		// discard the high byte of the results
-		page := c.Read(uint16(ptr+c.X) & 0x00ff)
-		col := c.Read(uint16(ptr+1+c.X) & 0x00ff) // no 0xxxff bug, apparently
+		// note: the pointer wraps around within page 0 (byte
+		// overflow)
+		col := c.read(uint16(ptr + c.X))
+		page := c.read(uint16(ptr + c.X + 1))
 		c.AbsAddress = mask.Word(page, col)
 
 	case IndirectY:
 
 		// only 1 pc increment, but 3 reads
-		ptr := c.Read(c.ProgramCounter)
+		ptr := c.read(c.ProgramCounter)
 		c.ProgramCounter++
 
 		// unlike IndirectX, the Y increment is applied -after- the
 		// indirection, not before. this means that a page cross is
 		// possible, and must be checked
-		page := c.Read(uint16(ptr) & 0x00ff)
-		col := c.Read(uint16(ptr+1) & 0x00ff)
-		c.AbsAddress = mask.Word(page, col)
-
-		c.AbsAddress += uint16(c.Y)
-		if c.AbsAddress&0xff00 != uint16(page)<<8 {
-			c.Cycles++
-		}
+		col := c.read(uint16(ptr))
+		page := c.read(uint16(ptr + 1))
+		c.index(mask.Word(page, col), c.Y, fast)
 
 	// 4 reads
 
@@ -362,9 +549,9 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 		// as a result, 4 reads are performed in total. however, the pc
 		// is still only incremented twice.
 
-		ptrCol := c.Read(c.ProgramCounter)
+		ptrCol := c.read(c.ProgramCounter)
 		c.ProgramCounter++
-		ptrPage := c.Read(c.ProgramCounter)
+		ptrPage := c.read(c.ProgramCounter)
 		ptr := mask.Word(ptrPage, ptrCol)
 		c.ProgramCounter++
 
@@ -372,35 +559,73 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 
 		// now that we have the pointer, get the contents of the addr,
 		// and its neighbour
-		realCol := c.Read(ptr)
+		realCol := c.read(ptr)
 
 		var realPage byte
-		if ptrCol == 0xff {
+		if ptrCol == 0xff && c.Variant != WDC65C02 {
 			// bug: while reading the bytes for the ptr, a page
 			// cross may have occurred. if so, read from 1st byte
 			// of the same page (0xYY00)
 			// http://www.6502.org/tutorials/6502opcodes.html#JMP
 			// https://atariwiki.org/wiki/Wiki.jsp?page=6502%20bugs
-			realPage = c.Read(ptr & 0xff00)
+			realPage = c.read(ptr & 0xff00)
 		} else {
 			// note: +1 since we need to fetch a new addr, which is
 			// always 2 bytes! otherwise, we would be reading the
 			// same byte twice, and the result would always be
 			// something like 0xabab (assuming the value remains
 			// unchanged), which is silly
-			realPage = c.Read(ptr + 1)
+			realPage = c.read(ptr + 1)
+		}
+		if c.Variant == WDC65C02 {
+			// the 65C02 fixed the bug, at the cost of 1 cycle
+			c.dummyRead(c.ProgramCounter - 1)
 		}
 
 		c.AbsAddress = mask.Word(realPage, realCol)
 
+	case ZeroPageIndirect:
+		ptr := c.read(c.ProgramCounter)
+		c.ProgramCounter++
+		col := c.read(uint16(ptr))
+		page := c.read(uint16(ptr + 1)) // wraps around within page 0
+		c.AbsAddress = mask.Word(page, col)
+
+	case AbsoluteIndirectX:
+		// like Indirect, but X is added to the pointer (without any
+		// page bug)
+		col := c.read(c.ProgramCounter)
+		c.ProgramCounter++
+		page := c.read(c.ProgramCounter)
+		c.dummyRead(c.ProgramCounter) // while adding X
+		c.ProgramCounter++
+		ptr := mask.Word(page, col) + uint16(c.X)
+		realCol := c.read(ptr)
+		realPage := c.read(ptr + 1)
+		c.AbsAddress = mask.Word(realPage, realCol)
+
 	}
 
-	c.M = c.Read(c.AbsAddress)
+	if acc == load || acc == modify {
+		c.M = c.read(c.AbsAddress)
+	}
 } // }}}
 
-// func (c *Cpu) execute(i func() byte) byte {
-// 	return i()
-// }
+// index adds an index register to the base addr. The low byte is added first,
+// and the high byte is only corrected on the next cycle, so a read of the
+// uncorrected address always occurs, except for a fast access (usually a
+// load) that does not cross a page (which is then complete in one cycle
+// fewer).
+func (c *Cpu) index(base uint16, i byte, fast bool) {
+	c.AbsAddress = base + uint16(i)
+	crossed := c.AbsAddress&0xff00 != base&0xff00
+	if crossed || !fast {
+		c.dummyRead(base&0xff00 | c.AbsAddress&0x00ff)
+	}
+	if crossed && fast {
+		c.Cycles++
+	}
+}
 
 // tick runs a single fetch/decode/execute cycle, setting c.Cycles to the
 // appropriate number. The Cpu must 'wait' this number of cycles before the
@@ -408,35 +633,143 @@ func (c *Cpu) decode(a AddressingMode) { // {{{
 func (c *Cpu) tick() error {
 	// https://en.wikipedia.org/wiki/Instruction_cycle#Summary_of_stages
 
-	// like OLC, this is not clock cycle accurate; we perform all the work
-	// at once, and simply wait until the correct number of cycles has
-	// elapsed. real hardware is slow and is always performing something
-	// every cycle, thus requiring the full number of cycles for execution
+	// like OLC, this is not clock cycle accurate (unless OnCycle is set);
+	// we perform all the work at once, and simply wait until the correct
+	// number of cycles has elapsed. real hardware is slow and is always
+	// performing something every cycle, thus requiring the full number of
+	// cycles for execution
 	//
 	// https://old.reddit.com/r/EmuDev/comments/pkgxws/what_cycles_really_are/hc3fqcf/
 
-	b := c.Read(c.ProgramCounter)
+	err := c.execute()
+	if c.OnCycle != nil {
+		// the cycles have already elapsed, one per bus access
+		c.Cycles = 0
+	}
+	return err
+}
+
+// execute services a pending interrupt, or executes the next instruction.
+func (c *Cpu) execute() error {
+	if c.halted {
+		return nil
+	}
+	c.pollCycles = 0
+
+	// interrupts are only serviced between instructions, if the last
+	// poll detected one. NMI has priority over IRQ
+	if c.doNmi || c.doIrq {
+		nmi := c.doNmi
+		c.doNmi, c.doIrq = false, false
+		c.inInterrupt = true
+		if nmi {
+			c.nmi()
+		} else {
+			c.irq()
+		}
+		if c.OnCycle != nil {
+			// the sequence is already over
+			c.inInterrupt = false
+		}
+		c.disabled = true
+		c.Cycles = 7
+		return nil
+	}
+	c.inInterrupt = false
+
+	pc := c.ProgramCounter
+	b := c.read(pc)
 	op, err := c.fetch(b)
 	c.ProgramCounter++ // decoding the opcode always requires 1 cycle, presumably even if unrecognised
 	if err != nil {
-		return nil // TODO: just noop and continue?
-		// return err
+		return err
+	}
+
+	c.Cycles = op.Cycles
+
+	if op.Unofficial {
+		switch c.Illegal {
+		case IllegalError:
+			c.ProgramCounter = pc
+			c.Cycles = 0
+			return &OpcodeError{PC: pc, Opcode: b, Name: op.Name}
+		case IllegalLog:
+			log.Printf("unofficial opcode %02x (%s) at %04x", b, op.Name, pc)
+		case IllegalNop:
+			// the operands are still fetched, but never used. the
+			// remaining cycles are spent re-reading the next opcode,
+			// so that both modes take op.Cycles
+			n := op.AddressingMode.Operands()
+			for range n {
+				c.dummyRead(c.ProgramCounter)
+				c.ProgramCounter++
+			}
+			for range int(op.Cycles) - 1 - n {
+				c.dummyRead(c.ProgramCounter)
+			}
+			c.disabled = c.Flags.DisableInterrupt
+			return nil
+		}
 	}
 
-	c.decode(op.AddressingMode)
+	acc := op.access
+	if acc != call {
+		// JSR fetches its own operand, since the return address is
+		// pushed between the 2 bytes
+		c.decode(&op)
+	}
+	old := c.M
+	disabled := c.Flags.DisableInterrupt
 
 	op.Instruction(c)
-	// c.execute(op.Instruction)
+
+	// CLI, SEI and PLP change the flag on their last cycle, i.e. after
+	// the poll, so their effect on IRQs is delayed by one instruction. RTI
+	// changes it earlier, so its effect is immediate. in cycle-accurate
+	// mode, this happens naturally
+	c.disabled = c.Flags.DisableInterrupt
+	if op.delaysIrq {
+		c.disabled = disabled
+	}
+
+	// read-modify-write instructions operate on M, which must be written
+	// back. the 6502 writes the unmodified byte first, while it computes
+	// the result; the 65C02 reads it again instead
+	switch {
+	case op.AddressingMode == Accumulator:
+		c.Accumulator = c.M
+	case acc == modify:
+		if c.Variant == WDC65C02 {
+			c.dummyRead(c.AbsAddress)
+		} else {
+			c.dummyWrite(c.AbsAddress, old)
+		}
+		c.write(c.AbsAddress, c.M)
+	}
+
+	if c.halted {
+		switch c.Jam {
+		case JamTrace:
+			log.Printf(
+				"KIL (%02x) at %04x; continuing. A:%02x X:%02x Y:%02x P:%02x SP:%02x",
+				b, pc, c.Accumulator, c.X, c.Y, c.flagsByte()|flagUnused, c.Stack,
+			)
+			c.halted = false
+		case JamError:
+			c.Cycles = 0
+			return &OpcodeError{PC: pc, Opcode: b, Name: op.Name}
+		default:
+			c.Cycles = 0
+		}
+	}
 
 	// TODO: does M need to be zeroed after instruction?
 
 	// 00 -> BRK -> nmi with fffa unset -> goto addr 0x0000 -> infinite 00 loop
-	if c.ProgramCounter == 0 && c.Bus.FakeRam[c.ProgramCounter] == 0 {
+	if c.ProgramCounter == 0 && c.Peek(c.ProgramCounter) == 0 {
 		return errors.New("Infinite loop; program terminated")
 	}
 
-	c.Cycles = op.Cycles
-
 	// if c.PageCrossed {
 	// 	c.Cycles++
 	// 	c.PageCrossed = false
@@ -445,21 +778,64 @@ func (c *Cpu) tick() error {
 	return nil
 }
 
-func (c *Cpu) loop() {
-	for {
-		if c.Cycles == 0 {
-			err := c.tick()
-			if err != nil {
-				// continue
-				panic(err)
-			}
+// Clock advances the Cpu by a single cycle. A new instruction is executed
+// once the previous one, and any pending stall, has completed.
+//
+// In cycle-accurate mode, Clock instead advances the Cpu by a whole
+// instruction (or a single cycle of stall), calling OnCycle for each cycle.
+func (c *Cpu) Clock() error {
+	if c.OnCycle != nil {
+		switch {
+		case c.Cycles > 0: // e.g. reset
+			c.Cycles--
+		case c.stall > 0:
+			c.stall--
+		case c.halted:
+			// time passes, but nothing happens
+		default:
+			return c.tick()
 		}
-		time.Sleep(Tick)
-		c.Cycles--
+		c.cycle()
+		return nil
+	}
 
-		// c.tick()
-		// time.Sleep(Tick * time.Duration(c.Cycles))
+	defer func() { c.TotalCycles++ }()
+	if c.Cycles == 0 {
+		if c.stall > 0 {
+			c.stall--
+			return nil
+		}
+		if err := c.tick(); err != nil {
+			return err
+		}
 	}
+	if c.Cycles > 0 {
+		c.Cycles--
+		if c.Cycles == c.pollCycles && !c.inInterrupt {
+			// usually the last cycle of the instruction (which may
+			// also be the first)
+			c.poll(c.disabled)
+		}
+	}
+	return nil
+}
+
+// Stall suspends the Cpu for the given number of cycles, once the current
+// instruction has completed. This is how other components (e.g. OAM DMA) take
+// over the Bus; the Cpu cannot stall itself.
+func (c *Cpu) Stall(cycles int) {
+	c.stall += cycles
+}
+
+// Stalled reports whether the Cpu is currently suspended by Stall.
+func (c *Cpu) Stalled() bool {
+	return c.stall > 0
+}
+
+// OddCycle reports whether the current cycle is odd. Some components (e.g.
+// DMA) must wait an extra cycle to align themselves to even cycles.
+func (c *Cpu) OddCycle() bool {
+	return c.TotalCycles%2 == 1
 }
 
 // fffa nmi
@@ -473,26 +849,107 @@ func (c *Cpu) loop() {
 // https://superuser.com/a/606770
 // https://www.pagetable.com/?p=410
 
+// An IrqSource is a component that can hold the IRQ input of the Cpu. The
+// input is shared (wired-OR): an IRQ is requested for as long as any of the
+// sources asserts it, and each source must release it once the interrupt has
+// been acknowledged (typically by writing to one of its registers).
+type IrqSource byte
+
+const (
+	IrqApu    IrqSource = 1 << iota // frame counter and DMC
+	IrqMapper                       // e.g. the MMC3 scanline counter
+)
+
+// SetIRQ sets the level of the IRQ input, as driven by src. Unlike NMI, IRQ is
+// level-triggered: the interrupt is serviced at the end of any instruction
+// during which the input is asserted (and DisableInterrupt is false), and is
+// forgotten if the input is released before then.
+func (c *Cpu) SetIRQ(src IrqSource, active bool) {
+	if active {
+		c.irqLines |= src
+	} else {
+		c.irqLines &^= src
+	}
+}
+
+// SetNMI sets the level of the NMI input (i.e. the PPU's NMI output). NMI is
+// edge-triggered: an interrupt is requested when the input becomes active,
+// and is serviced after the current instruction, even if the input has been
+// released since. To request another NMI, the input must be released first.
+func (c *Cpu) SetNMI(active bool) {
+	if active && !c.nmiLine {
+		c.nmiPending = true
+	}
+	c.nmiLine = active
+}
+
+// poll decides whether an interrupt will be serviced after the current
+// instruction. This happens at the end of the penultimate cycle of every
+// instruction; disabled is the DisableInterrupt flag at that point.
+//
+// https://www.nesdev.org/wiki/CPU_interrupts#Detailed_interrupt_behavior
+func (c *Cpu) poll(disabled bool) {
+	c.doNmi = c.nmiPending
+	c.doIrq = c.irqLines != 0 && !disabled
+}
+
 // Jumps to the address found at 0xfffa. This interrupt cannot be ignored.
 func (c *Cpu) nmi() {
-	// async interrupt (after curr instr; cannot be ignored)
-	c.Write(0x0100|uint16(c.Stack), byte(c.ProgramCounter>>8)) // store high byte first
-	c.Stack--
-	c.Write(0x0100|uint16(c.Stack), byte(c.ProgramCounter))
-	c.Stack--
+	// async interrupt (after curr instr; cannot be ignored). the opcode
+	// of the next instruction is fetched (twice), then discarded
+	c.nmiPending = false
+	c.dummyRead(c.ProgramCounter)
+	c.dummyRead(c.ProgramCounter)
+	c.interrupt(0xfffa, false)
+}
 
-	c.Flags.B = false
-	c.Flags.Unused = true // not sure if necessary
+// interrupt pushes the ProgramCounter and flags, then jumps to the address
+// found at vector. This is shared by BRK and the interrupts; only BRK sets the
+// B flag of the pushed byte.
+//
+// If an NMI occurs before the flags are pushed, it hijacks a BRK or IRQ: the
+// sequence continues as usual, but jumps to the NMI vector, and the NMI is
+// considered serviced.
+func (c *Cpu) interrupt(vector uint16, brk bool) {
+	c.push(byte(c.ProgramCounter >> 8)) // store high byte first
+	c.push(byte(c.ProgramCounter))
+
+	if vector == 0xfffe && c.nmiPending {
+		c.nmiPending = false
+		vector = 0xfffa
+	}
+
+	flags := c.flagsByte() | flagUnused
+	if brk {
+		flags |= flagB
+	} else {
+		flags &^= flagB
+	}
+	c.push(flags)
 	c.Flags.DisableInterrupt = true
-	c.Write(0x0100|uint16(c.Stack), c.flagsByte())
-	c.Stack--
+	if c.Variant == WDC65C02 {
+		c.Flags.Decimal = false
+	}
 
-	c.AbsAddress = 0xfffa
-	col := c.Read(c.AbsAddress)
-	page := c.Read(c.AbsAddress + 1)
+	c.AbsAddress = vector
+	col := c.read(c.AbsAddress)
+	page := c.read(c.AbsAddress + 1)
 	c.ProgramCounter = mask.Word(page, col)
+}
 
-	c.Cycles = 8
+// Reset simulates the reset signal (e.g. at power-on, or when the reset button
+// is pressed): registers are cleared, and execution resumes at the address
+// found at 0xfffc. The reset sequence takes 8 cycles.
+//
+// The interrupt inputs are left as they are, since they are driven by other
+// components.
+func (c *Cpu) Reset() {
+	c.nmiPending = false
+	c.doNmi, c.doIrq = false, false
+	c.inInterrupt = false
+	c.stall = 0
+	c.halted = false
+	c.reset()
 }
 
 // Jumps to the address found at 0xfffc.
@@ -508,12 +965,17 @@ func (c *Cpu) reset() {
 	c.Flags.Negative = false
 	c.Flags.Overflow = false
 	c.Flags.Unused = true // not sure if necessary
-	c.Flags.DisableInterrupt = false
+	// interrupts stay disabled until the program is ready for them (e.g.
+	// the APU frame IRQ is enabled at power-on)
+	c.Flags.DisableInterrupt = true
+	c.disabled = true
 	c.Flags.Zero = false
 	c.Flags.Carry = false
 	c.Flags.B = false
 	c.Flags.Decimal = false
 
+	// the vector is read at once; the remaining cycles elapse while
+	// c.Cycles counts down
 	c.AbsAddress = 0xfffc
 	col := c.Read(c.AbsAddress)
 	page := c.Read(c.AbsAddress + 1)
@@ -524,30 +986,12 @@ func (c *Cpu) reset() {
 	c.Cycles = 8
 }
 
-// Jumps to the address found at 0xfffe. This interrupt may be ignored if the
-// DisableInterrupt flag is true.
+// Jumps to the address found at 0xfffe. The interrupt is only serviced if
+// DisableInterrupt was false when polled.
 func (c *Cpu) irq() {
-	if c.Flags.DisableInterrupt {
-		return
-	}
-
 	// https://www.nesdev.org/wiki/CPU_interrupts#IRQ_and_NMI_tick-by-tick_execution
 
-	c.Write(0x0100|uint16(c.Stack), byte(c.ProgramCounter>>8)) // store high byte first
-	c.Stack--
-	c.Write(0x0100|uint16(c.Stack), byte(c.ProgramCounter))
-	c.Stack--
-
-	c.Flags.B = false
-	c.Flags.Unused = true // not sure if necessary
-	c.Flags.DisableInterrupt = true
-	c.Write(0x0100|uint16(c.Stack), c.flagsByte())
-	c.Stack--
-
-	c.AbsAddress = 0xfffe // not fffc (reset)
-	col := c.Read(c.AbsAddress)
-	page := c.Read(c.AbsAddress + 1)
-	c.ProgramCounter = mask.Word(page, col)
-
-	c.Cycles = 7
+	c.dummyRead(c.ProgramCounter)
+	c.dummyRead(c.ProgramCounter)
+	c.interrupt(0xfffe, false) // not fffc (reset)
 }
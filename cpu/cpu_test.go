@@ -1,31 +1,55 @@
 package cpu
 
 import (
+	"fmt"
+	"io"
+	"log"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"gone/mask"
 	"gone/mem"
 )
 
+// newCpu returns a Cpu whose cartridge space is writable, so that test
+// programs can be loaded at 0x8000.
+func newCpu() Cpu {
+	b := &mem.Bus{}
+	if err := b.Register(mem.NewMemory(0x4020, 0xffff)); err != nil {
+		panic(err)
+	}
+	return Cpu{Bus: b}
+}
+
 func TestLoadProgram(t *testing.T) {
 	// unhelpfully, this test program is nowhere to be found on OLC's repo
 	program := "A2 0A 8E 00 00 A2 03 8E 01 00 AC 00 00 A9 00 18 6D 01 00 88 D0 FA 8D 02 00 EA EA EA" // 28 bytes
 	// 162 10 142 ...
 
-	C := Cpu{Bus: &mem.Bus{}}
+	C := newCpu()
 	C.LoadProgram([]byte(program), 0x8000)
-	assert.Equal(t, C.Bus.FakeRam[0x8000], uint8(0xa2))
-	assert.Equal(t, C.Bus.FakeRam[0x8001], uint8(0x0a))
-	assert.Equal(t, C.Bus.FakeRam[0x8002], uint8(0x8e))
-	assert.Equal(t, C.Bus.FakeRam[0x801b], uint8(0xea))
-	assert.Equal(t, C.Bus.FakeRam[0x801c], uint8(0))
+	assert.Equal(t, C.Peek(0x8000), uint8(0xa2))
+	assert.Equal(t, C.Peek(0x8001), uint8(0x0a))
+	assert.Equal(t, C.Peek(0x8002), uint8(0x8e))
+	assert.Equal(t, C.Peek(0x801b), uint8(0xea))
+	assert.Equal(t, C.Peek(0x801c), uint8(0))
 
-	assert.Equal(t, Opcodes[C.Bus.FakeRam[0x8000]].Name, "LDX")
-	assert.Equal(t, Opcodes[C.Bus.FakeRam[0x8001]].Name, "ASL")
-	assert.Equal(t, Opcodes[C.Bus.FakeRam[0x8002]].Name, "STX")
-	assert.Equal(t, Opcodes[C.Bus.FakeRam[0x801b]].Name, "NOP")
-	assert.Equal(t, Opcodes[C.Bus.FakeRam[0x801c]].Name, "BRK")
+	assert.Equal(t, opcodes[C.Peek(0x8000)].Name, "LDX")
+	assert.Equal(t, opcodes[C.Peek(0x8001)].Name, "ASL")
+	assert.Equal(t, opcodes[C.Peek(0x8002)].Name, "STX")
+	assert.Equal(t, opcodes[C.Peek(0x801b)].Name, "NOP")
+	assert.Equal(t, opcodes[C.Peek(0x801c)].Name, "BRK")
+}
+
+func TestDebugCartridge(t *testing.T) {
+	// the blank cartridge cannot be provided around another Device, so the
+	// program could not be loaded
+	C := Cpu{Bus: &mem.Bus{}}
+	assert.NoError(t, C.Bus.Register(mem.NewMemory(0x6000, 0x7fff)))
+	assert.EqualError(t, C.Debug([]byte("EA"), 0x8000),
+		"range 4020-ffff overlaps existing device at 6000-7fff")
 }
 
 func TestThirty(t *testing.T) {
@@ -35,24 +59,24 @@ func TestThirty(t *testing.T) {
 	// A=1e (30), X=3, Y=0
 	// page 0: [0a 03 1e] (10 3 30)
 	//
-	// once this is done, 3 noops are called, then a BRK, which triggers an
-	// NMI, effectively (writing a bunch of stuff to the stack and) jumping
-	// to 0x0.
+	// once this is done, 3 noops are called, then a BRK, which (like an
+	// IRQ) effectively (writes a bunch of stuff to the stack and) jumps to
+	// 0x0.
 	//
 	// at that point, the cpu decodes 1e and executes ASL on 0 in an
 	// infinite loop.
 	program := "A2 0A 8E 00 00 A2 03 8E 01 00 AC 00 00 A9 00 18 6D 01 00 88 D0 FA 8D 02 00 EA EA EA" // 28 bytes
 
-	C := Cpu{Bus: &mem.Bus{}}
+	C := newCpu()
 	// C.Debug([]byte(program), 0x8000)
 
 	offset := uint16(0x8000)
 	C.LoadProgram([]byte(program), offset)
-	C.Bus.FakeRam[0xfffc] = 0x00 // reset
-	C.Bus.FakeRam[0xfffd] = 0x80 // ?
+	C.Write(0xfffc, 0x00) // reset
+	C.Write(0xfffd, 0x80) // ?
 	C.ProgramCounter = offset
 
-	assert.Equal(t, Opcodes[C.Bus.FakeRam[C.ProgramCounter]].Name, "LDX")
+	assert.Equal(t, opcodes[C.Peek(C.ProgramCounter)].Name, "LDX")
 
 	for _, cpuState := range []struct {
 		M        uint8
@@ -72,45 +96,45 @@ func TestThirty(t *testing.T) {
 		{M: 3, A: 3, X: 3, Y: 0xa, InstName: "DEY"},
 		{M: 3, A: 3, X: 3, Y: 9, InstName: "BNE"},
 
-		{M: 0x6d, A: 3, X: 3, Y: 9, InstName: "ADC"}, // note: we jumped back
+		{M: 0x03, A: 3, X: 3, Y: 9, InstName: "ADC"}, // note: we jumped back (the destination is not read)
 		{M: 0x03, A: 6, X: 3, Y: 9, InstName: "DEY"},
 		{M: 0x03, A: 6, X: 3, Y: 8, InstName: "BNE"},
 
 		// {{{
-		{M: 0x6d, A: 6, X: 3, Y: 8, InstName: "ADC"},
+		{M: 0x03, A: 6, X: 3, Y: 8, InstName: "ADC"},
 		{M: 0x03, A: 9, X: 3, Y: 8, InstName: "DEY"},
 		{M: 0x03, A: 9, X: 3, Y: 7, InstName: "BNE"},
 
-		{M: 0x6d, A: 9, X: 3, Y: 7, InstName: "ADC"},
+		{M: 0x03, A: 9, X: 3, Y: 7, InstName: "ADC"},
 		{M: 0x03, A: 12, X: 3, Y: 7, InstName: "DEY"},
 		{M: 0x03, A: 12, X: 3, Y: 6, InstName: "BNE"},
 
-		{M: 0x6d, A: 12, X: 3, Y: 6, InstName: "ADC"},
+		{M: 0x03, A: 12, X: 3, Y: 6, InstName: "ADC"},
 		{M: 0x03, A: 15, X: 3, Y: 6, InstName: "DEY"},
 		{M: 0x03, A: 15, X: 3, Y: 5, InstName: "BNE"},
 
-		{M: 0x6d, A: 15, X: 3, Y: 5, InstName: "ADC"},
+		{M: 0x03, A: 15, X: 3, Y: 5, InstName: "ADC"},
 		{M: 0x03, A: 18, X: 3, Y: 5, InstName: "DEY"},
 		{M: 0x03, A: 18, X: 3, Y: 4, InstName: "BNE"},
 
-		{M: 0x6d, A: 18, X: 3, Y: 4, InstName: "ADC"},
+		{M: 0x03, A: 18, X: 3, Y: 4, InstName: "ADC"},
 		{M: 0x03, A: 21, X: 3, Y: 4, InstName: "DEY"},
 		{M: 0x03, A: 21, X: 3, Y: 3, InstName: "BNE"},
 
-		{M: 0x6d, A: 21, X: 3, Y: 3, InstName: "ADC"},
+		{M: 0x03, A: 21, X: 3, Y: 3, InstName: "ADC"},
 		{M: 0x03, A: 24, X: 3, Y: 3, InstName: "DEY"},
 		{M: 0x03, A: 24, X: 3, Y: 2, InstName: "BNE"},
 
-		{M: 0x6d, A: 24, X: 3, Y: 2, InstName: "ADC"},
+		{M: 0x03, A: 24, X: 3, Y: 2, InstName: "ADC"},
 		{M: 0x03, A: 27, X: 3, Y: 2, InstName: "DEY"},
 		{M: 0x03, A: 27, X: 3, Y: 1, InstName: "BNE"},
 
-		{M: 0x6d, A: 27, X: 3, Y: 1, InstName: "ADC"},
+		{M: 0x03, A: 27, X: 3, Y: 1, InstName: "ADC"},
 		{M: 0x03, A: 30, X: 3, Y: 1, InstName: "DEY"},
 		{M: 0x03, A: 30, X: 3, Y: 0, InstName: "BNE"},
 		// }}}
 
-		{M: 0x6d, A: 30, X: 3, Y: 0, InstName: "STA"},
+		{M: 0x03, A: 30, X: 3, Y: 0, InstName: "STA"},
 		{M: 0x1e, A: 30, X: 3, Y: 0, InstName: "NOP"},
 		{M: 0x1e, A: 30, X: 3, Y: 0, InstName: "NOP"},
 		{M: 0x1e, A: 30, X: 3, Y: 0, InstName: "NOP"},
@@ -118,10 +142,10 @@ func TestThirty(t *testing.T) {
 
 		// UB from here on
 		{M: 0x1e, A: 30, X: 3, Y: 0, InstName: "ASL"},
-		{M: 0x78, A: 30, X: 3, Y: 0, InstName: ""},
+		{M: 0x3c, A: 0x3c, X: 3, Y: 0, InstName: "SLO"}, // ASL A
 	} {
 		_ = C.tick()
-		currInst := Opcodes[C.Bus.FakeRam[C.ProgramCounter]].Name
+		currInst := opcodes[C.Peek(C.ProgramCounter)].Name
 		assert.Equal(t, C.M, cpuState.M, "incorrect M at %s", currInst)
 		assert.Equal(t, C.Accumulator, cpuState.A, "incorrect A at %s", currInst)
 		assert.Equal(t, C.X, cpuState.X, "incorrect X at %s", currInst)
@@ -129,7 +153,720 @@ func TestThirty(t *testing.T) {
 		assert.Equal(t, currInst, cpuState.InstName)
 	}
 
-	assert.Equal(t, C.Bus.FakeRam[0], uint8(10))
-	assert.Equal(t, C.Bus.FakeRam[1], uint8(3))
-	assert.Equal(t, C.Bus.FakeRam[2], uint8(30))
+	assert.Equal(t, C.Peek(0), uint8(10))
+	assert.Equal(t, C.Peek(1), uint8(3))
+	assert.Equal(t, C.Peek(2), uint8(30))
+}
+
+// latch is a Device whose value changes every time it is read, similar to a
+// controller's shift register.
+type latch struct{ n byte }
+
+func (l *latch) Range() (uint16, uint16) { return 0x4016, 0x4016 }
+
+func (l *latch) Read(addr uint16, readonly bool) byte {
+	if readonly {
+		return l.n
+	}
+	l.n++
+	return l.n
+}
+
+func (l *latch) Write(addr uint16, data byte) {}
+
+func TestPeek(t *testing.T) {
+	C := newCpu()
+	l := &latch{}
+	assert.NoError(t, C.Bus.Register(l))
+
+	assert.Equal(t, C.Peek(0x4016), uint8(0))
+	assert.Equal(t, C.Peek(0x4016), uint8(0))
+	assert.Equal(t, C.Read(0x4016), uint8(1))
+	assert.Equal(t, C.Read(0x4016), uint8(2))
+	assert.Equal(t, C.Peek(0x4016), uint8(2))
+
+	// the debugger only ever peeks
+	m := model{cpu: &C}
+	_ = m.status()
+	_ = m.renderPage(0x4010)
+	assert.Equal(t, l.n, uint8(2))
+}
+
+// run runs the Cpu until the current instruction (or interrupt sequence) has
+// completed.
+func run(c *Cpu) {
+	for {
+		_ = c.Clock()
+		if c.Cycles == 0 && !c.Stalled() {
+			return
+		}
+	}
+}
+
+// newInterruptCpu returns a Cpu running the given program at 0x8000, with
+// interrupt handlers (NOPs) at 0xa000 (NMI) and 0x9000 (IRQ/BRK).
+func newInterruptCpu(program string, accurate bool) Cpu {
+	C := newCpu()
+	if accurate {
+		C.OnCycle = func() {}
+	}
+	C.LoadProgram([]byte(program), 0x8000)
+	C.LoadProgram([]byte("EA EA"), 0x9000)
+	C.LoadProgram([]byte("EA EA"), 0xa000)
+	C.LoadProgram([]byte("00 A0 00 80 00 90"), 0xfffa)
+	C.ProgramCounter = 0x8000
+	C.Stack = 0xfd
+	return C
+}
+
+func TestIRQ(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		C := newInterruptCpu("EA EA EA EA", accurate)
+		C.Flags.DisableInterrupt = true
+		C.SetIRQ(IrqApu, true)
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x8002), "IRQ should be masked")
+
+		// the IRQ is polled during the next instruction
+		C.Flags.DisableInterrupt = false
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x8003))
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x9000))
+		assert.Equal(t, C.TotalCycles, uint64(2+2+2+7))
+		assert.True(t, C.Flags.DisableInterrupt)
+		assert.Equal(t, C.Stack, byte(0xfa))
+		assert.Equal(t, C.Peek(0x01fb)&(flagB|flagUnused), byte(flagUnused))
+
+		// the IRQ is level-triggered; once released (acknowledged), it
+		// is not serviced again
+		C.SetIRQ(IrqApu, false)
+		C.Flags.DisableInterrupt = false
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x9002))
+
+		// an IRQ that is released before the poll is never serviced,
+		// but one that is still held by another source is
+		C.SetIRQ(IrqApu, true)
+		C.SetIRQ(IrqMapper, true)
+		C.SetIRQ(IrqApu, false)
+		C.ProgramCounter = 0x8000
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x9000))
+	}
+}
+
+func TestNMI(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		C := newInterruptCpu("EA EA EA EA", accurate)
+
+		// NMI cannot be masked, and has priority over IRQ
+		C.Flags.DisableInterrupt = false
+		C.SetIRQ(IrqApu, true)
+		C.SetNMI(true)
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0xa000))
+		assert.Equal(t, C.Stack, byte(0xfa))
+
+		// the first instruction of the handler is always executed.
+		// holding the NMI does not generate another one
+		C.SetIRQ(IrqApu, false)
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0xa002))
+
+		// the NMI is edge-triggered
+		C.SetNMI(false)
+		C.SetNMI(true)
+		C.SetNMI(false)
+		C.ProgramCounter = 0x8000
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0xa000))
+	}
+}
+
+func TestInterruptDelay(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		// CLI takes effect after the next instruction
+		C := newInterruptCpu("58 EA EA", accurate) // CLI; NOP; NOP
+		C.Flags.DisableInterrupt = true
+		C.SetIRQ(IrqApu, true)
+		run(&C)
+		assert.False(t, C.Flags.DisableInterrupt)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x8002))
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x9000))
+
+		// an IRQ can still occur right after SEI; the pushed flags
+		// have I set
+		C = newInterruptCpu("78 EA", accurate) // SEI; NOP
+		C.Flags.DisableInterrupt = false
+		C.SetIRQ(IrqApu, true)
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x9000))
+		assert.Equal(t, C.Peek(0x01fb)&0x04, byte(0x04))
+	}
+}
+
+func TestBranchInterrupt(t *testing.T) {
+	// a taken branch that does not cross a page polls for interrupts
+	// before its 3rd cycle, not its last one; an IRQ raised during the 2nd
+	// cycle waits for the next instruction
+	for _, tc := range []struct {
+		at   uint64 // the IRQ is raised after this cycle
+		want uint16 // the return address
+	}{
+		{at: 1, want: 0x8002},
+		{at: 2, want: 0x8003},
+	} {
+		for _, accurate := range []bool{false, true} {
+			C := newInterruptCpu("D0 00 EA EA", accurate) // BNE +0; NOP; NOP
+			C.Flags.DisableInterrupt = false
+			raise := func() {
+				if C.TotalCycles == tc.at {
+					C.SetIRQ(IrqApu, true)
+				}
+			}
+			if accurate {
+				C.OnCycle = raise
+			}
+			for C.ProgramCounter != 0x9000 && C.TotalCycles < 20 {
+				_ = C.Clock()
+				if !accurate {
+					raise()
+				}
+			}
+			assert.Equal(t, C.ProgramCounter, uint16(0x9000))
+			assert.Equal(t, mask.Word(C.Peek(0x01fd), C.Peek(0x01fc)), tc.want,
+				"accurate: %v, at: %d", accurate, tc.at)
+		}
+	}
+}
+
+func TestBRK(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		C := newInterruptCpu("00 00 EA", accurate)
+		C.Flags.DisableInterrupt = false
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x9000))
+		assert.Equal(t, C.TotalCycles, uint64(7))
+		assert.True(t, C.Flags.DisableInterrupt)
+		assert.Equal(t, C.Peek(0x01fd), byte(0x80))
+		assert.Equal(t, C.Peek(0x01fc), byte(0x02)) // the padding byte is skipped
+		assert.Equal(t, C.Peek(0x01fb)&flagB, byte(flagB))
+	}
+
+	// an NMI during the first 4 cycles of BRK hijacks it. B is still set,
+	// and the NMI is not serviced again
+	C := newInterruptCpu("00 00 EA", true)
+	C.OnCycle = func() {
+		if C.TotalCycles == 3 {
+			C.SetNMI(true)
+		}
+	}
+	run(&C)
+	assert.Equal(t, C.ProgramCounter, uint16(0xa000))
+	assert.Equal(t, C.Peek(0x01fb)&flagB, byte(flagB))
+	run(&C)
+	assert.Equal(t, C.ProgramCounter, uint16(0xa001))
+}
+
+// recorder is a Device that records every access, along with the cycle on
+// which it occurred.
+type recorder struct {
+	cpu  *Cpu
+	data byte
+	log  []string
+}
+
+func (r *recorder) Range() (uint16, uint16) { return 0x4000, 0x401f }
+
+func (r *recorder) Read(addr uint16, readonly bool) byte {
+	if !readonly {
+		r.log = append(r.log, fmt.Sprintf("%d r %04x", r.cpu.TotalCycles, addr))
+	}
+	return r.data
+}
+
+func (r *recorder) Write(addr uint16, data byte) {
+	r.log = append(r.log, fmt.Sprintf("%d w %04x %02x", r.cpu.TotalCycles, addr, data))
+	r.data = data
+}
+
+func TestCycleAccurate(t *testing.T) {
+	C := newCpu()
+	r := &recorder{cpu: &C}
+	assert.NoError(t, C.Bus.Register(r))
+	calls := 0
+	C.OnCycle = func() { calls++ }
+
+	C.LoadProgram([]byte(
+		"EE 10 40"+ // INC $4010
+			" A2 10"+ // LDX #$10
+			" 9D 00 40"+ // STA $4000,X
+			" BD 00 40", // LDA $4000,X
+	), 0x8000)
+	C.ProgramCounter = 0x8000
+
+	for range 4 {
+		assert.NoError(t, C.Clock())
+	}
+	assert.Equal(t, r.log, []string{
+		"4 r 4010", "5 w 4010 00", "6 w 4010 01", // dummy write of the old value
+		"12 r 4010", "13 w 4010 00", // dummy read before the high byte is fixed
+		"17 r 4010", // no page cross
+	})
+	assert.Equal(t, C.TotalCycles, uint64(6+2+5+4))
+	assert.Equal(t, calls, 6+2+5+4)
+	assert.Equal(t, C.Cycles, byte(0))
+
+	// both modes take the same number of cycles
+	program := "A2 0A 8E 00 00 A2 03 8E 01 00 AC 00 00 A9 00 18 6D 01 00 88 D0 FA 8D 02 00 EA EA EA"
+	for _, accurate := range []bool{false, true} {
+		C := newCpu()
+		if accurate {
+			C.OnCycle = func() {}
+		}
+		C.LoadProgram([]byte(program), 0x8000)
+		C.ProgramCounter = 0x8000
+		for C.ProgramCounter != 0x8019 || C.Cycles > 0 {
+			assert.NoError(t, C.Clock())
+		}
+		assert.Equal(t, C.TotalCycles, uint64(113), "accurate: %v", accurate)
+		assert.Equal(t, C.Peek(2), byte(30))
+	}
+}
+
+func TestUnofficial(t *testing.T) {
+	official := 0
+	for _, op := range opcodes {
+		if !op.Unofficial {
+			official++
+		}
+	}
+	assert.Equal(t, len(opcodes), 256)
+	assert.Equal(t, official, 151)
+
+	C := newCpu()
+	C.LoadProgram([]byte(`
+		A7 10
+		A9 F0 A2 3C 87 20
+		A9 04 C7 30
+		A9 02 07 40
+		1C 00 80
+	`), 0x8000)
+	C.Write(0x10, 0x80)
+	C.Write(0x30, 0x05)
+	C.Write(0x40, 0x81)
+	C.ProgramCounter = 0x8000
+
+	_ = C.tick() // LAX $10
+	assert.Equal(t, C.Accumulator, byte(0x80))
+	assert.Equal(t, C.X, byte(0x80))
+	assert.True(t, C.Flags.Negative)
+
+	_ = C.tick()
+	_ = C.tick()
+	_ = C.tick() // SAX $20
+	assert.Equal(t, C.Peek(0x20), byte(0x30))
+
+	_ = C.tick()
+	_ = C.tick() // DCP $30
+	assert.Equal(t, C.Peek(0x30), byte(0x04))
+	assert.True(t, C.Flags.Zero)
+	assert.True(t, C.Flags.Carry)
+
+	_ = C.tick()
+	_ = C.tick() // SLO $40
+	assert.Equal(t, C.Peek(0x40), byte(0x02))
+	assert.Equal(t, C.Accumulator, byte(0x02))
+	assert.True(t, C.Flags.Carry)
+
+	_ = C.tick() // NOP $8000,X
+	assert.Equal(t, C.ProgramCounter, uint16(0x8013))
+	assert.Equal(t, C.Cycles, byte(4))
+}
+
+func TestJam(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		C := newInterruptCpu("02 EA", accurate)
+		assert.NoError(t, C.Clock())
+		assert.True(t, C.Halted())
+
+		// interrupts are ignored, but time passes
+		C.SetNMI(true)
+		for range 10 {
+			assert.NoError(t, C.Clock())
+		}
+		assert.Equal(t, C.ProgramCounter, uint16(0x8001))
+		assert.Greater(t, C.TotalCycles, uint64(10))
+
+		C.Reset()
+		assert.False(t, C.Halted())
+	}
+
+	C := newInterruptCpu("02 EA", false)
+	C.Jam = JamError
+	var oe *OpcodeError
+	assert.ErrorAs(t, C.tick(), &oe)
+	assert.Equal(t, *oe, OpcodeError{PC: 0x8000, Opcode: 0x02, Name: "KIL"})
+	assert.True(t, C.Halted())
+
+	C = newInterruptCpu("02 EA", false)
+	C.Jam = JamTrace
+	assert.NoError(t, C.tick())
+	assert.False(t, C.Halted())
+	assert.NoError(t, C.tick())
+	assert.Equal(t, C.ProgramCounter, uint16(0x8002))
+}
+
+func TestIllegal(t *testing.T) {
+	// LAX $1234; INX
+	program := "AF 34 12 E8"
+
+	C := newInterruptCpu(program, false)
+	assert.NoError(t, C.tick())
+	assert.Equal(t, C.ProgramCounter, uint16(0x8003))
+
+	C = newInterruptCpu(program, false)
+	C.Illegal = IllegalError
+	var oe *OpcodeError
+	assert.ErrorAs(t, C.tick(), &oe)
+	assert.Equal(t, *oe, OpcodeError{PC: 0x8000, Opcode: 0xaf, Name: "LAX"})
+	assert.Equal(t, C.ProgramCounter, uint16(0x8000))
+	assert.Equal(t, oe.Error(), "illegal opcode af (LAX) at 8000")
+
+	C = newInterruptCpu(program, false)
+	C.Illegal = IllegalLog
+	assert.NoError(t, C.tick())
+	assert.Equal(t, C.ProgramCounter, uint16(0x8003))
+
+	C = newInterruptCpu(program, false)
+	C.Illegal = IllegalNop
+	C.Write(0x1234, 0x55)
+	assert.NoError(t, C.tick())
+	assert.Equal(t, C.ProgramCounter, uint16(0x8003))
+	assert.Equal(t, C.Accumulator, byte(0))
+	assert.Equal(t, C.Cycles, byte(4))
+	assert.NoError(t, C.tick())
+	assert.Equal(t, C.X, byte(1))
+}
+
+func TestArithmetic(t *testing.T) {
+	for _, tc := range []struct {
+		op      string
+		variant Variant
+		decimal bool
+		a, m    byte
+		carry   bool
+
+		want     byte
+		c, v, z  bool
+		negative bool
+	}{
+		{op: "ADC", a: 0x50, m: 0x50, want: 0xa0, v: true, negative: true},
+		{op: "ADC", a: 0xff, m: 0x01, want: 0x00, c: true, z: true},
+		{op: "ADC", a: 0xff, m: 0x00, carry: true, want: 0x00, c: true, z: true},
+		{op: "ADC", a: 0x01, m: 0x01, want: 0x02},
+		{op: "SBC", a: 0x50, m: 0xf0, carry: true, want: 0x60},
+		{op: "SBC", a: 0x50, m: 0xb0, carry: true, want: 0xa0, v: true, negative: true},
+		{op: "SBC", a: 0x05, m: 0x05, want: 0xff, negative: true},
+
+		// the 2A03 ignores Decimal
+		{op: "ADC", decimal: true, a: 0x09, m: 0x01, want: 0x0a},
+
+		{op: "ADC", variant: NMOS6502, decimal: true, a: 0x09, m: 0x01, want: 0x10},
+		{op: "ADC", variant: NMOS6502, decimal: true, a: 0x12, m: 0x34, want: 0x46},
+		{op: "ADC", variant: NMOS6502, decimal: true, a: 0x58, m: 0x46, carry: true, want: 0x05, c: true, v: true, negative: true}, // N, V from the unadjusted sum
+		{op: "ADC", variant: NMOS6502, decimal: true, a: 0x99, m: 0x01, want: 0x00, c: true, negative: true},
+		{op: "SBC", variant: NMOS6502, decimal: true, a: 0x46, m: 0x12, carry: true, want: 0x34, c: true},
+		{op: "SBC", variant: NMOS6502, decimal: true, a: 0x40, m: 0x13, carry: true, want: 0x27, c: true},
+		{op: "SBC", variant: NMOS6502, decimal: true, a: 0x12, m: 0x21, carry: true, want: 0x91, negative: true},
+
+		// the 65C02 sets N and Z from the result
+		{op: "ADC", variant: WDC65C02, decimal: true, a: 0x99, m: 0x01, want: 0x00, c: true, z: true},
+		{op: "SBC", variant: WDC65C02, decimal: true, a: 0x40, m: 0x13, carry: true, want: 0x27, c: true},
+		{op: "SBC", variant: WDC65C02, decimal: true, a: 0x12, m: 0x21, carry: true, want: 0x91, negative: true},
+		{op: "SBC", variant: WDC65C02, decimal: true, a: 0x00, m: 0x01, carry: true, want: 0x99, negative: true},
+
+		// invalid BCD: the digits are adjusted in a different order
+		{op: "SBC", variant: NMOS6502, decimal: true, a: 0x10, m: 0x0f, carry: true, want: 0x0b, c: true},
+		{op: "SBC", variant: WDC65C02, decimal: true, a: 0x10, m: 0x0f, carry: true, want: 0xfb, c: true, negative: true},
+	} {
+		C := newCpu()
+		C.Variant = tc.variant
+		C.Flags.Decimal = tc.decimal
+		C.Flags.Carry = tc.carry
+		C.Accumulator = tc.a
+		C.M = tc.m
+		if tc.op == "ADC" {
+			C.ADC()
+		} else {
+			C.SBC()
+		}
+
+		msg := fmt.Sprintf("%s %02x %02x on %s", tc.op, tc.a, tc.m, tc.variant)
+		assert.Equal(t, C.Accumulator, tc.want, msg)
+		assert.Equal(t, C.Flags.Carry, tc.c, msg)
+		assert.Equal(t, C.Flags.Overflow, tc.v, msg)
+		assert.Equal(t, C.Flags.Zero, tc.z, msg)
+		assert.Equal(t, C.Flags.Negative, tc.negative, msg)
+	}
+}
+
+func TestWDC65C02(t *testing.T) {
+	assert.Equal(t, len(opcodes65C02), 256)
+	for _, op := range opcodes65C02 {
+		assert.NotEqual(t, op.Name, "KIL")
+	}
+
+	C := newCpu()
+	C.Variant = WDC65C02
+	C.LoadProgram([]byte(`
+		A9 0F A2 F0 A0 55
+		64 10
+		DA 7A
+		04 11
+		B2 20
+		89 F0
+		02 FF 03
+		80 01 EA
+		6C FF 90
+	`), 0x8000)
+	C.Write(0x10, 0xff)
+	C.Write(0x11, 0xf0)
+	C.Write(0x20, 0x00)
+	C.Write(0x21, 0x90)
+	C.Write(0x9000, 0x77)
+	C.Write(0x90ff, 0x00)
+	C.Write(0x9100, 0xa0) // on the 6502, 0x9000 would be read instead
+	C.ProgramCounter = 0x8000
+	C.Stack = 0xfd
+
+	for range 3 {
+		_ = C.tick()
+	}
+
+	_ = C.tick() // STZ $10
+	assert.Equal(t, C.Peek(0x10), byte(0))
+
+	_ = C.tick() // PHX
+	_ = C.tick() // PLY
+	assert.Equal(t, C.Y, byte(0xf0))
+	assert.Equal(t, C.Stack, byte(0xfd))
+
+	_ = C.tick() // TSB $11
+	assert.Equal(t, C.Peek(0x11), byte(0xff))
+	assert.True(t, C.Flags.Zero)
+
+	_ = C.tick() // LDA ($20)
+	assert.Equal(t, C.Accumulator, byte(0x77))
+
+	C.Flags.Negative = true
+	_ = C.tick() // BIT #$f0
+	assert.False(t, C.Flags.Zero)
+	assert.True(t, C.Flags.Negative)
+
+	_ = C.tick() // NOP #$ff
+	_ = C.tick() // NOP
+	assert.Equal(t, C.ProgramCounter, uint16(0x8013))
+	assert.Equal(t, C.Cycles, byte(1))
+
+	_ = C.tick() // BRA +1
+	assert.Equal(t, C.ProgramCounter, uint16(0x8016))
+
+	_ = C.tick() // JMP ($90ff)
+	assert.Equal(t, C.ProgramCounter, uint16(0xa000))
+
+	// N and Z are valid in decimal mode
+	C.Flags.Decimal = true
+	C.Flags.Carry = false
+	C.Accumulator = 0x99
+	C.M = 0x01
+	C.Cycles = 2
+	C.ADC()
+	assert.Equal(t, C.Accumulator, byte(0))
+	assert.True(t, C.Flags.Carry)
+	assert.True(t, C.Flags.Zero)
+	assert.False(t, C.Flags.Negative)
+	assert.Equal(t, C.Cycles, byte(3))
+}
+
+func TestWDC65C02Timing(t *testing.T) {
+	for _, tc := range []struct {
+		variant Variant
+		program string
+		x       byte
+		cycles  uint64
+		log     []string
+	}{
+		{variant: NMOS6502, program: "1E 00 40", cycles: 7, log: []string{ // ASL $4000,X
+			"4 r 4000", "5 r 4000", "6 w 4000 81", "7 w 4000 02",
+		}},
+		{variant: WDC65C02, program: "1E 00 40", cycles: 6, log: []string{
+			"4 r 4000", "5 r 4000", "6 w 4000 02",
+		}},
+		{variant: WDC65C02, program: "1E F0 3F", x: 0x10, cycles: 7, log: []string{
+			"5 r 4000", "6 r 4000", "7 w 4000 02", // 3f00 is read on cycle 4
+		}},
+		{variant: WDC65C02, program: "FE 00 40", cycles: 7, log: []string{ // INC $4000,X
+			"4 r 4000", "5 r 4000", "6 r 4000", "7 w 4000 82",
+		}},
+	} {
+		C := newCpu()
+		C.Variant = tc.variant
+		r := &recorder{cpu: &C, data: 0x81}
+		assert.NoError(t, C.Bus.Register(r))
+		C.OnCycle = func() {}
+		C.X = tc.x
+		C.LoadProgram([]byte(tc.program), 0x8000)
+		C.ProgramCounter = 0x8000
+		assert.NoError(t, C.Clock())
+		assert.Equal(t, C.TotalCycles, tc.cycles, "%v %s", tc.variant, tc.program)
+		assert.Equal(t, r.log, tc.log, "%v %s", tc.variant, tc.program)
+	}
+}
+
+func TestWDC65C02Nop(t *testing.T) {
+	for _, accurate := range []bool{false, true} {
+		C := newInterruptCpu("03 5C 00 01 EA", accurate)
+		C.Variant = WDC65C02
+		C.Illegal = IllegalError // the NOPs are documented
+		C.SetIRQ(IrqApu, true)
+		C.Flags.DisableInterrupt = true
+
+		// the IRQ is polled on the only cycle of a 1 cycle NOP
+		assert.NoError(t, C.Clock())
+		assert.Equal(t, C.ProgramCounter, uint16(0x8001))
+		assert.Equal(t, C.TotalCycles, uint64(1))
+		C.Flags.DisableInterrupt = false
+		C.ProgramCounter = 0x8000
+		run(&C)
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x9000), "accurate: %v", accurate)
+		assert.Equal(t, C.TotalCycles, uint64(1+1+7))
+
+		C.SetIRQ(IrqApu, false)
+		C.ProgramCounter = 0x8001
+		run(&C)
+		assert.Equal(t, C.ProgramCounter, uint16(0x8004))
+		assert.Equal(t, C.TotalCycles, uint64(1+1+7+8))
+	}
+}
+
+// both modes take the same number of cycles, for every opcode of every
+// Variant
+func TestCycleCount(t *testing.T) {
+	log.SetOutput(io.Discard) // IllegalLog
+	defer log.SetOutput(os.Stderr)
+
+	policies := []IllegalPolicy{IllegalExecute, IllegalError, IllegalLog, IllegalNop}
+	for _, v := range []Variant{Ricoh2A03, NMOS6502, WDC65C02} {
+		for _, p := range policies {
+			for b := range 256 {
+				program := []byte(fmt.Sprintf("%02X 10 02", b))
+				var cycles [2]uint64
+				for i, accurate := range []bool{false, true} {
+					C := newCpu()
+					C.Variant = v
+					C.Illegal = p
+					C.Jam = JamTrace
+					if accurate {
+						C.OnCycle = func() {}
+					}
+					C.LoadProgram(program, 0x8000)
+					C.ProgramCounter = 0x8000
+					C.Stack = 0xfd
+					C.Write(0x0000, 0xea) // BRK, RTI etc. jump there
+					_ = C.Clock()
+					for C.Cycles > 0 {
+						_ = C.Clock()
+					}
+					cycles[i] = C.TotalCycles
+				}
+				assert.Equal(t, cycles[1], cycles[0], "%v %v %02x", v, p, b)
+			}
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	program := "1A 1A" // INC A on the 65C02, NOP on the 6502
+
+	C1 := newInterruptCpu(program, false)
+	C2 := newInterruptCpu(program, false)
+	C2.Variant = WDC65C02
+
+	// instrument INC A, on C2 only
+	incs := 0
+	C2.Table = NewTable(C2.Variant)
+	inc := C2.Table[0x1a].Instruction
+	C2.Table[0x1a].Instruction = func(c *Cpu) byte {
+		incs++
+		return inc(c)
+	}
+
+	for range 2 {
+		assert.NoError(t, C1.tick())
+		assert.NoError(t, C2.tick())
+	}
+	assert.Equal(t, C1.Accumulator, byte(0))
+	assert.Equal(t, C2.Accumulator, byte(2))
+	assert.Equal(t, incs, 2)
+	assert.Equal(t, opcodes[0x1a].Name, "NOP")
+
+	// an empty entry cannot be executed
+	C1.Table[0xea] = Opcode{}
+	C1.ProgramCounter = 0x9000
+	var oe *OpcodeError
+	assert.ErrorAs(t, C1.tick(), &oe)
+	assert.Equal(t, oe.Error(), "illegal opcode ea (unknown) at 9000")
+
+	// errors name the Opcode of the Cpu's own Table (0x1a is a NOP in
+	// opcodes)
+	C2.Table[0x1a].Unofficial = true
+	C2.Illegal = IllegalError
+	C2.ProgramCounter = 0x8000
+	assert.ErrorAs(t, C2.tick(), &oe)
+	assert.Equal(t, oe.Error(), "illegal opcode 1a (INC) at 8000")
+
+	// what the Cpu needs to know about each Instruction is worked out
+	// once, when the Table is built
+	tbl := NewTable(Ricoh2A03)
+	assert.Equal(t, tbl[0x0e].access, modify) // ASL abs
+	assert.Equal(t, tbl[0x8d].access, store)  // STA abs
+	assert.Equal(t, tbl[0x6c].access, jump)   // JMP (ind)
+	assert.True(t, tbl[0x58].delaysIrq)       // CLI
+	assert.False(t, tbl[0x40].delaysIrq)      // RTI
+}
+
+func TestDisassemble(t *testing.T) {
+	C := newCpu()
+	C.LoadProgram([]byte("A9 10 BD 00 02 0A D0 FC 6C FC FF A7 10 02"), 0x8000)
+
+	var lines []string
+	for addr := uint16(0x8000); addr < 0x800e; {
+		s, n := C.table().Disassemble(C.Peek, addr)
+		lines = append(lines, s)
+		addr += uint16(n)
+	}
+	assert.Equal(t, lines, []string{
+		"LDA #$10",
+		"LDA $0200,X",
+		"ASL A",
+		"BNE $8004",
+		"JMP ($FFFC)",
+		"*LAX $10",
+		"*KIL",
+	})
+
+	s, _ := NewTable(WDC65C02).Disassemble(C.Peek, 0x800d)
+	assert.Equal(t, s, "NOP #$00") // documented on the 65C02
 }
@@ -3,11 +3,15 @@ package cpu
 import (
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/davecgh/go-spew/spew"
+
+	"gone/mem"
 )
 
 type model struct {
@@ -25,9 +29,13 @@ const pages = 65536 / 16
 // initial command. To not perform an initial command return nil.
 func (m model) Init() tea.Cmd {
 	log.Println("started debugger:", m.program)
+	if m.program == nil {
+		// already loaded (e.g. a cartridge)
+		return nil
+	}
 	m.cpu.LoadProgram([]byte(m.program), m.offset)
-	m.cpu.Bus.FakeRam[0xfffc] = 0x00 // reset
-	m.cpu.Bus.FakeRam[0xfffd] = 0x80 // ?
+	m.cpu.Write(0xfffc, 0x00) // reset
+	m.cpu.Write(0xfffd, 0x80) // ?
 	m.cpu.ProgramCounter = m.offset
 	return nil
 }
@@ -47,7 +55,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// 	return m, nil
 
 		case " ", "j":
-			// op := Opcodes[m.cpu.Bus.FakeRam[m.cpu.ProgramCounter]]
+			// op := Opcodes[m.cpu.Read(m.cpu.ProgramCounter)]
 			// op.Instruction(m.cpu)
 			m.prevPC = m.cpu.ProgramCounter
 			err := m.cpu.tick()
@@ -67,8 +75,9 @@ func (m model) renderPage(start uint16) string {
 		panic("start must be a multiple of 16")
 	}
 	s := fmt.Sprintf("%04x | ", start)
-	for i, b := range m.cpu.Bus.FakeRam[start : start+16] {
-		if start+uint16(i) == m.cpu.ProgramCounter {
+	for i := range uint16(16) {
+		b := m.cpu.Peek(start + i)
+		if start+i == m.cpu.ProgramCounter {
 			s += fmt.Sprintf("[%02x] ", b)
 		} else {
 			s += fmt.Sprintf(" %02x  ", b)
@@ -98,7 +107,7 @@ func (m model) status() string {
 
 	mem := fmt.Sprintf(
 		` M: %x`,
-		m.cpu.Bus.FakeRam[m.cpu.AbsAddress],
+		m.cpu.Peek(m.cpu.AbsAddress),
 	)
 	if m.cpu.ProgramCounter-m.cpu.AbsAddress > 2 {
 		mem += fmt.Sprintf(` (at %04x)`, m.cpu.AbsAddress)
@@ -155,15 +164,40 @@ func (m model) View() string {
 		),
 		"",
 		// strconv.FormatInt(int64(m.cpu.ProgramCounter), 16),
-		// TODO: addrmode int -> name
-		spew.Sdump(Opcodes[m.cpu.Bus.FakeRam[m.cpu.ProgramCounter]]),
+		m.disassemble(),
+		spew.Sdump(m.cpu.table()[m.cpu.Peek(m.cpu.ProgramCounter)]),
 	)
 }
 
+func (m model) disassemble() string {
+	s, _ := m.cpu.table().Disassemble(m.cpu.Peek, m.cpu.ProgramCounter)
+	return s
+}
+
+// DebugLog is the file that Debug logs to.
+var DebugLog = filepath.Join(os.TempDir(), "gone.log")
+
 // Debug loads the program into memory at the given offset, then starts an
-// interactive TUI.
-func (c *Cpu) Debug(program []byte, offset uint16) {
-	lf, _ := tea.LogToFile("/tmp/gone.log", "")
+// interactive TUI. If nothing is connected to the cartridge space of the Bus
+// (0x8000-0xffff), a blank cartridge is provided.
+//
+// If program is nil, nothing is loaded, and execution continues from the
+// current ProgramCounter; offset is then only used to decide which pages to
+// show. To clock the other components of a console while debugging, use the
+// cycle-accurate mode (see OnCycle).
+func (c *Cpu) Debug(program []byte, offset uint16) error {
+	if !c.Bus.Mapped(0x8000) {
+		// fails if only part of the space is claimed (e.g. by a
+		// Device at 0x6000), since the program could not be loaded
+		if err := c.Bus.Register(mem.NewMemory(0x4020, 0xffff)); err != nil {
+			return err
+		}
+	}
+
+	lf, err := tea.LogToFile(DebugLog, "")
+	if err != nil {
+		return err
+	}
 	defer lf.Close()
 
 	m, err := tea.NewProgram(model{
@@ -172,10 +206,11 @@ func (c *Cpu) Debug(program []byte, offset uint16) {
 		offset:  offset,
 	}).Run()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	x := m.(model)
 	if x.error != nil {
 		fmt.Println("Error:", x.error)
 	}
+	return nil
 }
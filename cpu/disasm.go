@@ -0,0 +1,62 @@
+package cpu
+
+import "fmt"
+
+// Disassemble decodes the instruction at addr into assembly, e.g. "LDA
+// $0200,X", reading its bytes with read (typically Cpu.Peek). The size of the
+// instruction (1 to 3 bytes) is also returned. Unofficial opcodes are prefixed
+// with '*', like in nestest.log.
+func (t *Table) Disassemble(read func(uint16) byte, addr uint16) (string, int) {
+	op := t[read(addr)]
+	if op.Instruction == nil {
+		return "???", 1
+	}
+
+	name := op.Name
+	if op.Unofficial {
+		name = "*" + name
+	}
+
+	lo := read(addr + 1)
+	word := uint16(read(addr+2))<<8 | uint16(lo)
+
+	var operand string
+	switch op.AddressingMode {
+	case Implied:
+	case Accumulator:
+		operand = "A"
+	case Immediate:
+		operand = fmt.Sprintf("#$%02X", lo)
+	case ZeroPage:
+		operand = fmt.Sprintf("$%02X", lo)
+	case ZeroPageX:
+		operand = fmt.Sprintf("$%02X,X", lo)
+	case ZeroPageY:
+		operand = fmt.Sprintf("$%02X,Y", lo)
+	case IndirectX:
+		operand = fmt.Sprintf("($%02X,X)", lo)
+	case IndirectY:
+		operand = fmt.Sprintf("($%02X),Y", lo)
+	case ZeroPageIndirect:
+		operand = fmt.Sprintf("($%02X)", lo)
+	case Relative:
+		// the destination, rather than the offset
+		operand = fmt.Sprintf("$%04X", addr+2+uint16(int8(lo)))
+	case Absolute:
+		operand = fmt.Sprintf("$%04X", word)
+	case AbsoluteX:
+		operand = fmt.Sprintf("$%04X,X", word)
+	case AbsoluteY:
+		operand = fmt.Sprintf("$%04X,Y", word)
+	case Indirect:
+		operand = fmt.Sprintf("($%04X)", word)
+	case AbsoluteIndirectX:
+		operand = fmt.Sprintf("($%04X,X)", word)
+	}
+
+	size := 1 + op.AddressingMode.Operands()
+	if operand == "" {
+		return name, size
+	}
+	return name + " " + operand, size
+}
@@ -0,0 +1,50 @@
+package cpu
+
+// https://www.nesdev.org/wiki/PPU_registers#OAMDMA
+// https://www.nesdev.org/wiki/DMA#OAM_DMA
+
+const (
+	OAMDMA  = 0x4014
+	oamData = 0x2004 // OAMDATA, on the PPU
+
+	dmaCycles = 513 // 1 wait cycle, then 256 alternating read/write cycles
+)
+
+// A Dma is the OAM DMA unit of the 2A03, mapped to 0x4014. Writing a byte XX
+// copies the page XX00-XXff to PPU OAM (via OAMDATA, starting at OAMADDR),
+// which is much faster than having the program write each byte itself.
+//
+// During the copy, the Cpu is suspended for 513 cycles, plus 1 if the write
+// occurred on an odd cycle.
+//
+// Since 0x4014 lies among the APU registers, a Dma is usually connected to the
+// Apu (which claims 0x4000-0x4017), rather than registered on the Bus itself.
+type Dma struct {
+	Cpu *Cpu
+}
+
+func (d *Dma) Range() (uint16, uint16) { return OAMDMA, OAMDMA }
+
+// Read returns open bus, since 0x4014 is write-only. Like the cartridge
+// expansion area, this is usually the high byte of the address.
+func (d *Dma) Read(addr uint16, readonly bool) byte {
+	return byte(addr >> 8)
+}
+
+func (d *Dma) Write(addr uint16, page byte) {
+	// the copy is performed all at once; the Cpu cannot observe the
+	// intermediate state anyway, since it is stalled
+	for i := range uint16(256) {
+		d.Cpu.Write(oamData, d.Cpu.Read(uint16(page)<<8|i))
+	}
+
+	// unless the Cpu is cycle accurate, the write is considered to occur
+	// on the first cycle of the instruction. either way, the Dma's own
+	// reads and writes do not take Cpu cycles; they happen during the
+	// stall
+	cycles := dmaCycles
+	if d.Cpu.OddCycle() {
+		cycles++
+	}
+	d.Cpu.Stall(cycles)
+}
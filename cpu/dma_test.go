@@ -0,0 +1,55 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// oam stands in for the PPU, recording writes to OAMDATA.
+type oam struct {
+	data [256]byte
+	addr byte
+}
+
+func (o *oam) Range() (uint16, uint16)              { return 0x2000, 0x2007 }
+func (o *oam) Read(addr uint16, readonly bool) byte { return 0 }
+
+func (o *oam) Write(addr uint16, data byte) {
+	if addr == oamData {
+		o.data[o.addr] = data
+		o.addr++
+	}
+}
+
+func TestDma(t *testing.T) {
+	C := newCpu()
+	o := &oam{addr: 0x10}
+	assert.NoError(t, C.Bus.Register(o))
+	assert.NoError(t, C.Bus.Register(&Dma{Cpu: &C}))
+
+	for i := range 256 {
+		C.Write(0x0200+uint16(i), byte(i))
+	}
+	C.LoadProgram([]byte("A9 02 8D 14 40 EA"), 0x8000) // LDA #$02; STA $4014; NOP
+	C.ProgramCounter = 0x8000
+
+	// LDA (2 cycles) and STA (4), then 513 cycles of DMA, since STA
+	// started on an even cycle
+	for range 2 + 4 + 513 {
+		assert.NoError(t, C.Clock())
+	}
+	assert.Equal(t, C.ProgramCounter, uint16(0x8005))
+	assert.Equal(t, o.data[0x10], byte(0x00)) // starts at OAMADDR, and wraps around
+	assert.Equal(t, o.data[0xff], byte(0xef))
+	assert.Equal(t, o.data[0x0f], byte(0xff))
+
+	assert.NoError(t, C.Clock())
+	assert.Equal(t, C.ProgramCounter, uint16(0x8006))
+	assert.Equal(t, C.TotalCycles, uint64(520))
+
+	// an extra cycle is needed on odd cycles
+	C.TotalCycles++
+	C.Write(OAMDMA, 0x02)
+	assert.Equal(t, C.stall, 514)
+}
@@ -1,8 +1,6 @@
 package cpu
 
 import (
-	"log"
-
 	"gone/mask"
 )
 
@@ -45,17 +43,45 @@ func (c *Cpu) branch(cond bool) {
 	// to false, no action is taken, and no cycles are added
 
 	if cond {
-		log.Println("will branch to", c.AbsAddress)
-
+		// log.Println("will branch to", c.AbsAddress)
+
+		// the next opcode is read while the PC is updated, and the
+		// opcode in the wrong page if the high byte must be fixed
+		//
+		// a taken branch that does not cross a page does not poll for
+		// interrupts on its last cycle; the result of the previous poll
+		// (before the operand was read) stands
+		doNmi, doIrq := c.doNmi, c.doIrq
+		c.dummyRead(c.ProgramCounter)
 		c.Cycles++
 		pageCrossed := c.AbsAddress&0xff00 != c.ProgramCounter&0xff00
-		c.ProgramCounter = c.AbsAddress
+		if !pageCrossed {
+			if c.OnCycle != nil {
+				c.doNmi, c.doIrq = doNmi, doIrq
+			} else {
+				// Clock polls 1 cycle early instead
+				c.pollCycles = 1
+			}
+		}
 		if pageCrossed {
+			c.dummyRead(c.ProgramCounter&0xff00 | c.AbsAddress&0x00ff)
 			c.Cycles++
 		}
+		c.ProgramCounter = c.AbsAddress
 	}
 }
 
+// push writes b to the stack. push = - after write, pull = + before read
+func (c *Cpu) push(b byte) {
+	c.write(0x0100|uint16(c.Stack), b) // + and | are both fine
+	c.Stack--
+}
+
+func (c *Cpu) pull() byte {
+	c.Stack++
+	return c.read(0x0100 | uint16(c.Stack))
+}
+
 // no instructions should ever PC++
 
 // ADC - Add with Carry (A += M)
@@ -93,26 +119,35 @@ func (c *Cpu) ADC() byte {
 	// into words and checks overflow (sum>255) explicitly. this behaviour
 	// seems 'inaccurate', as the 6502 would not have had this luxury
 
-	sum := c.Accumulator + c.M
-	if sum < c.Accumulator {
-		// C 	Carry Flag 	Set if overflow in bit 7
-		// "bit 7" refers to the result (A+M)
-		c.Flags.Carry = true
+	// the NMOS 6502 (but not the 2A03) adds in BCD if Decimal is set
+	if c.decimal() {
+		c.addDecimal(c.M)
+		return 0
 	}
+	c.Accumulator = c.add(c.M)
+	return 0
+}
 
-	c.Accumulator = sum
+// add returns A+b+Carry, and sets all the flags accordingly; this is shared by
+// ADC and SBC.
+func (c *Cpu) add(b byte) byte {
+	sum := c.Accumulator + b
+	carry := sum < c.Accumulator // C: set if the sum wrapped around
 	if c.Flags.Carry {
-		c.Accumulator += 1 // just 1?
+		sum++
+		carry = carry || sum == 0
 	}
+	c.Flags.Carry = carry
+	c.setNZ(sum)
 
-	c.setNZ(c.Accumulator)
-
-	// OLC's truth table is great, but the xor stuff is confusing
-	operandsLike := c.Accumulator&0x80 == c.M&0x80
+	// V: set if both operands have the same sign, but the sum does not
+	// (i.e. signed overflow). OLC's truth table is great, but the xor
+	// stuff is confusing
+	operandsLike := c.Accumulator&0x80 == b&0x80
 	sumUnlike := c.Accumulator&0x80 != sum&0x80
 	c.Flags.Overflow = operandsLike && sumUnlike
 
-	return 0
+	return sum
 }
 
 // AND - Logical AND
@@ -127,7 +162,7 @@ func (c *Cpu) AND() byte {
 func (c *Cpu) ASL() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#ASL
 	c.Flags.Carry = c.M&0x80 > 0 // old bit 7
-	c.M <<= 2
+	c.M <<= 1
 	c.setNZ(c.M)
 	return 0
 }
@@ -157,7 +192,7 @@ func (c *Cpu) BEQ() byte {
 func (c *Cpu) BIT() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#BIT
 	// result of A&M is -not- kept
-	c.Flags.Zero = c.M&c.Accumulator > 0
+	c.Flags.Zero = c.M&c.Accumulator == 0
 	c.Flags.Negative = c.M&0x80 > 0 // bit 7 set
 	c.Flags.Overflow = c.M&0x40 > 0 // bit 6 set
 	return 0
@@ -187,11 +222,12 @@ func (c *Cpu) BPL() byte {
 // BRK - Force Interrupt
 //
 // Note that the opcode for this instruction is 0x00. Thus if called, the
-// program will probably be halted.
+// program will probably be halted. BRK shares its vector (0xfffe) with IRQ;
+// the handler can tell them apart by the B flag pushed to the stack.
 func (c *Cpu) BRK() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#BRK
-	c.ProgramCounter++
-	c.nmi()
+	c.ProgramCounter++ // the byte after BRK is skipped (it was read by decode)
+	c.interrupt(0xfffe, true)
 	return 0
 }
 
@@ -323,14 +359,23 @@ func (c *Cpu) INY() byte {
 // JMP - Jump
 func (c *Cpu) JMP() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#JMP
-	c.ProgramCounter = uint16(c.M) // TODO: zero page? or wait for 2nd byte?
+	c.ProgramCounter = c.AbsAddress
 	return 0
 }
 
 // JSR - Jump to Subroutine
 func (c *Cpu) JSR() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#JSR
-	// TODO: haven't touched the stack yet
+	// the return address (minus one, i.e. the last byte of the JSR) is
+	// pushed before the high byte of the destination is even read
+	col := c.read(c.ProgramCounter)
+	c.ProgramCounter++
+	c.dummyRead(0x0100 | uint16(c.Stack))
+	c.push(byte(c.ProgramCounter >> 8))
+	c.push(byte(c.ProgramCounter))
+	page := c.read(c.ProgramCounter)
+	c.AbsAddress = mask.Word(page, col)
+	c.ProgramCounter = c.AbsAddress
 	return 0
 }
 
@@ -362,7 +407,7 @@ func (c *Cpu) LDY() byte {
 func (c *Cpu) LSR() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#LSR
 	c.Flags.Carry = c.M&0x01 > 0 // old bit 0
-	c.M >>= 2
+	c.M >>= 1
 	c.setNZ(c.M)
 	return 0
 }
@@ -384,13 +429,18 @@ func (c *Cpu) ORA() byte {
 // PHA - Push Accumulator
 func (c *Cpu) PHA() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#PHA
-	// + and | are both fine
-	stackAddr := 0x0100 | uint16(c.Stack) // TODO: seems often repeated
-	c.Write(stackAddr, c.Accumulator)
-	c.Stack-- // push = - after write, pull = + before read
+	c.push(c.Accumulator)
 	return 0
 }
 
+// The B flag (and bit 5) do not exist in the status register; they only
+// appear in the byte pushed to the stack. B is set by BRK and PHP, and clear
+// for interrupts.
+const (
+	flagB      = 1 << 4
+	flagUnused = 1 << 5
+)
+
 func (c *Cpu) flagsByte() byte {
 	var flags byte
 	for i, f := range []bool{
@@ -413,19 +463,15 @@ func (c *Cpu) flagsByte() byte {
 // PHP - Push Processor Status
 func (c *Cpu) PHP() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#PHP
-	stackAddr := 0x0100 | uint16(c.Stack)
-
-	c.Write(stackAddr, c.flagsByte())
-	c.Stack--
+	c.push(c.flagsByte() | flagB | flagUnused)
 	return 0
 }
 
 // PLA - Pull Accumulator
 func (c *Cpu) PLA() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#PLA
-	c.Stack++
-	stackAddr := 0x0100 | uint16(c.Stack)
-	c.Accumulator = c.Read(stackAddr)
+	c.dummyRead(0x0100 | uint16(c.Stack)) // while incrementing the Stack
+	c.Accumulator = c.pull()
 	c.setNZ(c.Accumulator)
 	return 0
 }
@@ -433,30 +479,30 @@ func (c *Cpu) PLA() byte {
 // PLP - Pull Processor Status
 func (c *Cpu) PLP() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#PLP
-	c.Stack++
-	stackAddr := 0x0100 | uint16(c.Stack)
-	newFlags := c.Read(stackAddr)
+	c.dummyRead(0x0100 | uint16(c.Stack))
+	c.setFlags(c.pull())
+	return 0
+}
 
+func (c *Cpu) setFlags(newFlags byte) {
 	c.Flags.Carry = newFlags&(1<<0) > 0
 	c.Flags.Zero = newFlags&(1<<1) > 0
 	c.Flags.DisableInterrupt = newFlags&(1<<2) > 0
 	c.Flags.Decimal = newFlags&(1<<3) > 0
-	c.Flags.B = newFlags&(1<<4) > 0
-	c.Flags.Unused = newFlags&(1<<5) > 0
+	// B and Unused are ignored
 	c.Flags.Overflow = newFlags&(1<<6) > 0
 	c.Flags.Negative = newFlags&(1<<7) > 0
-
-	return 0
 }
 
 // ROL - Rotate Left
 func (c *Cpu) ROL() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#ROL
-	// similar to ASL
+	// similar to ASL, but the old Carry is shifted in
+	carry := c.Flags.Carry
 	c.Flags.Carry = c.M&0x80 > 0 // old bit 7
-	c.M <<= 2
+	c.M <<= 1
 
-	if c.Flags.Carry {
+	if carry {
 		c.M |= 0x01
 	}
 
@@ -467,10 +513,11 @@ func (c *Cpu) ROL() byte {
 // ROR - Rotate Right
 func (c *Cpu) ROR() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#ROR
+	carry := c.Flags.Carry
 	c.Flags.Carry = c.M&0x01 > 0 // old bit 0
-	c.M >>= 2
+	c.M >>= 1
 
-	if c.Flags.Carry {
+	if carry {
 		c.M |= 0x80
 	}
 
@@ -484,17 +531,16 @@ func (c *Cpu) RTI() byte {
 	// invoked at the end of an interrupt
 
 	// restore flags from stack
-	c.PLP()
+	c.dummyRead(0x0100 | uint16(c.Stack))
+	c.setFlags(c.pull())
 
 	// hmm (OLC does this)
 	// c.Flags.B = !c.Flags.B
 	// c.Flags.Unused = !c.Flags.Unused
 
 	// restore the PC from stack
-	c.Stack++
-	col := c.Read(uint16(c.Stack))
-	c.Stack++
-	page := c.Read(uint16(c.Stack))
+	col := c.pull()
+	page := c.pull()
 	c.ProgramCounter = mask.Word(page, col)
 
 	return 0
@@ -503,12 +549,15 @@ func (c *Cpu) RTI() byte {
 // RTS - Return from Subroutine
 func (c *Cpu) RTS() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#RTS
-	c.Stack++
-	stackAddr := 0x0100 | uint16(c.Stack)
+	c.dummyRead(0x0100 | uint16(c.Stack))
 	// The RTS instruction is used at the end of a subroutine to return to
 	// the calling routine. It pulls the program counter (minus one) from
-	// the stack. (so we correct it with +1?)
-	c.ProgramCounter = uint16(c.Read(stackAddr)) + 1
+	// the stack, so we correct it with +1 (which takes a cycle of its own)
+	col := c.pull()
+	page := c.pull()
+	c.ProgramCounter = mask.Word(page, col)
+	c.dummyRead(c.ProgramCounter)
+	c.ProgramCounter++
 	return 0
 }
 
@@ -516,10 +565,12 @@ func (c *Cpu) RTS() byte {
 func (c *Cpu) SBC() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#SBC
 
-	// wild guess?
-	// c.M = -c.M  // 256-M+1
-	c.M ^= 0xff // 256-M
-	c.ADC()
+	// A - M - (1-C) = A + ^M + C, since ^M = -M-1 (two's complement)
+	if c.decimal() {
+		c.subDecimal(c.M)
+		return 0
+	}
+	c.Accumulator = c.add(^c.M)
 	// if c.Flags.Carry {
 	// 	c.Accumulator -= 1
 	// }
@@ -569,7 +620,7 @@ func (c *Cpu) SEI() byte {
 func (c *Cpu) STA() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#STA
 	c.M = c.Accumulator
-	c.Write(c.AbsAddress, c.M)
+	c.write(c.AbsAddress, c.M)
 	return 0
 }
 
@@ -578,8 +629,8 @@ func (c *Cpu) STX() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#STX
 	c.M = c.X
 	// log.Println("writing byte", c.M, "to addr", c.AbsAddress)
-	c.Write(c.AbsAddress, c.M)
-	// log.Println("page 0", c.Bus.FakeRam[:16])
+	c.write(c.AbsAddress, c.M)
+	// log.Println("page 0", c.Bus.Ram[:16])
 	return 0
 }
 
@@ -587,7 +638,7 @@ func (c *Cpu) STX() byte {
 func (c *Cpu) STY() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#STY
 	c.M = c.Y
-	c.Write(c.AbsAddress, c.M)
+	c.write(c.AbsAddress, c.M)
 	return 0
 }
 
@@ -610,9 +661,7 @@ func (c *Cpu) TAY() byte {
 // TSX - Transfer Stack Pointer to X
 func (c *Cpu) TSX() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#TSX
-	c.Stack++
-	stackAddr := 0x0100 | uint16(c.Stack)
-	c.X = c.Read(stackAddr)
+	c.X = c.Stack
 	c.setNZ(c.X)
 	return 0
 }
@@ -628,9 +677,7 @@ func (c *Cpu) TXA() byte {
 // TXS - Transfer X to Stack Pointer
 func (c *Cpu) TXS() byte {
 	// https://www.nesdev.org/obelisk-6502-guide/reference.html#TXS
-	stackAddr := 0x0100 | uint16(c.Stack)
-	c.Write(stackAddr, c.X)
-	c.Stack--
+	c.Stack = c.X // flags are not affected
 	return 0
 }
 
@@ -0,0 +1,165 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runProgram loads program at 0x8000, and executes n instructions.
+func runProgram(c *Cpu, program string, n int) {
+	c.LoadProgram([]byte(program), 0x8000)
+	c.ProgramCounter = 0x8000
+	for range n {
+		_ = c.tick()
+	}
+}
+
+func TestShift(t *testing.T) {
+	for _, tc := range []struct {
+		program string
+		a       byte
+		carry   bool
+
+		wantA     byte
+		wantMem   byte // at 0x0010
+		wantCarry bool
+	}{
+		{program: "0A", a: 0x81, wantA: 0x02, wantCarry: true}, // ASL A
+		{program: "4A", a: 0x03, wantA: 0x01, wantCarry: true}, // LSR A
+		{program: "2A", a: 0x80, carry: true, wantA: 0x01, wantCarry: true},
+		{program: "2A", a: 0x40, wantA: 0x80},
+		{program: "6A", a: 0x01, wantA: 0x00, wantCarry: true}, // ROR A
+		{program: "6A", a: 0x02, carry: true, wantA: 0x81},
+
+		// read-modify-write instructions write the result back
+		{program: "06 10", wantMem: 0x84, wantCarry: true}, // ASL $10
+		{program: "46 10", wantMem: 0x61},                  // LSR $10
+		{program: "26 10", carry: true, wantMem: 0x85, wantCarry: true},
+		{program: "66 10", wantMem: 0x61}, // ROR $10
+		{program: "E6 10", wantMem: 0xc3}, // INC $10
+		{program: "C6 10", wantMem: 0xc1}, // DEC $10
+	} {
+		C := newCpu()
+		C.Accumulator = tc.a
+		C.Flags.Carry = tc.carry
+		C.Write(0x0010, 0xc2)
+		runProgram(&C, tc.program, 1)
+
+		assert.Equal(t, C.Accumulator, tc.wantA, tc.program)
+		assert.Equal(t, C.Flags.Carry, tc.wantCarry, tc.program)
+		if tc.wantMem > 0 {
+			assert.Equal(t, C.Peek(0x0010), tc.wantMem, tc.program)
+		}
+	}
+}
+
+func TestJMP(t *testing.T) {
+	C := newCpu()
+	runProgram(&C, "4C 34 12", 1) // JMP $1234
+	assert.Equal(t, C.ProgramCounter, uint16(0x1234))
+
+	C = newCpu()
+	C.Write(0x0200, 0x78)
+	C.Write(0x0201, 0x56)
+	runProgram(&C, "6C 00 02", 1) // JMP ($0200)
+	assert.Equal(t, C.ProgramCounter, uint16(0x5678))
+}
+
+func TestSubroutine(t *testing.T) {
+	C := newCpu()
+	C.LoadProgram([]byte("20 00 90 EA"), 0x8000) // JSR $9000; NOP
+	C.LoadProgram([]byte("E8 60"), 0x9000)       // INX; RTS
+	C.ProgramCounter = 0x8000
+	C.Stack = 0xfd
+
+	_ = C.tick()
+	assert.Equal(t, C.ProgramCounter, uint16(0x9000))
+	assert.Equal(t, C.Stack, byte(0xfb))
+	assert.Equal(t, C.Peek(0x01fd), byte(0x80))
+	assert.Equal(t, C.Peek(0x01fc), byte(0x02)) // return address minus one
+
+	_ = C.tick()
+	_ = C.tick()
+	assert.Equal(t, C.ProgramCounter, uint16(0x8003))
+	assert.Equal(t, C.Stack, byte(0xfd))
+	assert.Equal(t, C.X, byte(1))
+}
+
+func TestRTI(t *testing.T) {
+	C := newCpu()
+	C.Stack = 0xfa
+	C.Write(0x01fb, 0b1100_0011) // N, V, Z, C
+	C.Write(0x01fc, 0x34)
+	C.Write(0x01fd, 0x12)
+	runProgram(&C, "40", 1)
+
+	assert.Equal(t, C.ProgramCounter, uint16(0x1234)) // unlike RTS, not +1
+	assert.Equal(t, C.Stack, byte(0xfd))
+	assert.True(t, C.Flags.Negative)
+	assert.True(t, C.Flags.Overflow)
+	assert.True(t, C.Flags.Zero)
+	assert.True(t, C.Flags.Carry)
+	assert.False(t, C.Flags.DisableInterrupt)
+}
+
+func TestStackTransfer(t *testing.T) {
+	C := newCpu()
+	C.Stack = 0xfd
+	runProgram(&C, "A2 80 9A A2 00 BA", 4) // LDX #$80; TXS; LDX #0; TSX
+	assert.Equal(t, C.Stack, byte(0x80))
+	assert.Equal(t, C.X, byte(0x80))
+	assert.True(t, C.Flags.Negative)
+	assert.Equal(t, C.Peek(0x01fd), byte(0)) // the stack is not touched
+}
+
+func TestBIT(t *testing.T) {
+	for _, tc := range []struct {
+		a, m byte
+
+		zero, negative, overflow bool
+	}{
+		{a: 0x01, m: 0x01},
+		{a: 0x02, m: 0x01, zero: true},
+		{a: 0x01, m: 0xc1, negative: true, overflow: true},
+		{a: 0x00, m: 0x40, zero: true, overflow: true},
+	} {
+		C := newCpu()
+		C.Accumulator = tc.a
+		C.Write(0x0010, tc.m)
+		runProgram(&C, "24 10", 1) // BIT $10
+
+		assert.Equal(t, C.Flags.Zero, tc.zero, "%02x & %02x", tc.a, tc.m)
+		assert.Equal(t, C.Flags.Negative, tc.negative, "%02x & %02x", tc.a, tc.m)
+		assert.Equal(t, C.Flags.Overflow, tc.overflow, "%02x & %02x", tc.a, tc.m)
+		assert.Equal(t, C.Accumulator, tc.a) // the result is not kept
+	}
+}
+
+func TestIndirect(t *testing.T) {
+	// the pointer is little endian, like any other address
+	C := newCpu()
+	C.X = 2
+	C.Write(0x0012, 0x34)
+	C.Write(0x0013, 0x02)
+	C.Write(0x0234, 0x99)
+	runProgram(&C, "A1 10", 1) // LDA ($10,X)
+	assert.Equal(t, C.Accumulator, byte(0x99))
+
+	C = newCpu()
+	C.Y = 1
+	C.Write(0x0020, 0x00)
+	C.Write(0x0021, 0x03)
+	C.Write(0x0301, 0x42)
+	runProgram(&C, "B1 20", 1) // LDA ($20),Y
+	assert.Equal(t, C.Accumulator, byte(0x42))
+
+	// the pointer wraps around within page 0
+	C = newCpu()
+	C.X = 0x01
+	C.Write(0x00ff, 0x00)
+	C.Write(0x0000, 0x04)
+	C.Write(0x0400, 0x17)
+	runProgram(&C, "A1 FE", 1) // LDA ($FE,X)
+	assert.Equal(t, C.Accumulator, byte(0x17))
+}
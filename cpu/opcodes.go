@@ -1,7 +1,10 @@
 package cpu
 
 // An Opcode is associated with a unique byte Value (0x00-0xff). There are 256
-// possible opcodes (16x16), but only 56 correspond to a valid Cpu instruction.
+// possible opcodes (16x16), but only 151 of them (56 instructions) are
+// official. The remaining 105 are Unofficial, but still do something
+// (sometimes useful, sometimes unpredictable), and are used by a number of
+// games and test ROMs.
 //
 // Importantly, the Opcode carries with it information on the AddressingMode
 // and number of Cycles that should elapse before the corresponding Instruction
@@ -34,8 +37,8 @@ type Opcode struct {
 	// just a byte) are passed to the func implicitly via the M field of c,
 	// -not- explicitly via func args.
 	//
-	// With the sole exception of BRK, Instructions -never- increment the
-	// PC. Cycles are incurred -only- in branching Instructions, and only
+	// With the exception of BRK and JSR, Instructions -never- increment
+	// the PC. Cycles are incurred -only- in branching Instructions, and only
 	// if the condition succeeds.
 	//
 	// The byte returned by the Instruction call is not memory data. It
@@ -49,14 +52,94 @@ type Opcode struct {
 
 	Name string // for debugger
 
+	// Unofficial opcodes are not documented by MOS, and arise from the way
+	// the instruction decoder is wired. Some (e.g. LAX, DCP) combine two
+	// official Instructions; others (e.g. SHA, ANE) depend on analog
+	// effects, and are only approximated.
+	//
+	// https://www.nesdev.org/wiki/CPU_unofficial_opcodes
+	Unofficial bool
+
+	// derived from the Name by NewTable, so that the Cpu does not have to
+	// look at the Name while executing
+	access    access
+	delaysIrq bool // changes DisableInterrupt after the poll (see execute)
+
+	// the index cycle is only taken on a page cross, even though the
+	// Instruction is not a load (see index)
+	fastIndex bool
+
 	// Value               byte // The Value received by the CPU
 	// NumBytes            int  // Always 1 to 3 (needed?)
 	// CrossesPageBoundary bool // if true, increases Cycles (i.e. wait more ticks)
 }
 
-// The Opcodes table lists all 151 (?) byte values recognised by the Cpu. These
-// values are mapped to 56 unique instructions.
-var Opcodes = map[byte]Opcode{
+// An access describes what an Instruction does with the byte at AbsAddress,
+// which determines the bus accesses performed by decode.
+type access int
+
+const (
+	load   access = iota // read M
+	store                // write M; M is never read
+	modify               // read M, then write the result back
+	jump                 // AbsAddress is the destination, and is not accessed
+	call                 // JSR; decoded by the Instruction itself
+)
+
+func accessOf(name string) access {
+	switch name {
+	case "STA", "STX", "STY", "STZ", "SAX", "SHA", "SHX", "SHY", "TAS":
+		return store
+	case "ASL", "LSR", "ROL", "ROR", "INC", "DEC", "TRB", "TSB",
+		"SLO", "RLA", "SRE", "RRA", "DCP", "ISC":
+		return modify
+	case "JMP":
+		return jump
+	case "JSR":
+		return call
+	}
+	return load
+}
+
+// A Table maps every byte value to an Opcode, and is what the Cpu actually
+// dispatches on. Each Cpu carries its own Table, built from the definitions
+// of its Variant, so Cpus of different Variants (or with patched Opcodes) can
+// coexist in the same process.
+type Table [256]Opcode
+
+// NewTable builds a Table from the definitions of the given Variant.
+//
+// To patch a Table, modify its entries (e.g. replace an Instruction), rather
+// than replacing them with new Opcodes: each entry also carries what the Cpu
+// needs to know about its Instruction, which only NewTable fills in.
+func NewTable(v Variant) *Table {
+	defs := opcodes
+	if v == WDC65C02 {
+		defs = opcodes65C02
+	}
+	var t Table
+	for b, op := range defs {
+		op.access = accessOf(op.Name)
+		switch op.Name {
+		case "CLI", "SEI", "PLP":
+			op.delaysIrq = true
+		}
+		t[b] = op
+	}
+	return &t
+}
+
+func (c *Cpu) table() *Table {
+	if c.Table == nil {
+		c.Table = NewTable(c.Variant)
+	}
+	return c.Table
+}
+
+// opcodes defines all 256 byte values of the NMOS 6502 (and 2A03): 151
+// official ones, mapped to 56 unique instructions, and 105 Unofficial ones.
+// It is only read when building a Table, and is never modified.
+var opcodes = map[byte]Opcode{
 	// Generated from http://www.6502.org/tutorials/6502opcodes.html
 
 	// OLC uses a 16x16 slice (Vec, in cpp) of opcodes, i.e. exhaustive.
@@ -68,13 +151,16 @@ var Opcodes = map[byte]Opcode{
 	// Not: in the event of illegal opcode (outside the 56), OLC invokes
 	// either NOP or XXX; not sure of the significance of this.
 	//
-	// My approach is to 1) allow Opcodes to exist as entities of their
+	// My approach is to 1) allow opcodes to exist as entities of their
 	// own, but allow them to reference an arbitrary (parent) Cpu via
 	// pointer, and 2) access Opcode via a (global) map, and default to a
 	// dummy opcode.
 
 	// TODO: is map index or slice index faster? slice index requires slice
 	// of len 256, map doesn't
+	//
+	// (answer: slice index, by far. the map is kept for readability, and
+	// converted to a Table)
 
 	0x69: {Instruction: (*Cpu).ADC, Name: "ADC", Cycles: 2, AddressingMode: Immediate},
 	0x65: {Instruction: (*Cpu).ADC, Name: "ADC", Cycles: 3, AddressingMode: ZeroPage},
@@ -235,4 +321,126 @@ var Opcodes = map[byte]Opcode{
 	0x68: {Instruction: (*Cpu).PLA, Name: "PLA", Cycles: 4, AddressingMode: Implied},
 	0x08: {Instruction: (*Cpu).PHP, Name: "PHP", Cycles: 3, AddressingMode: Implied},
 	0x28: {Instruction: (*Cpu).PLP, Name: "PLP", Cycles: 4, AddressingMode: Implied},
+
+	// unofficial
+	//
+	// https://www.nesdev.org/wiki/CPU_unofficial_opcodes
+	// https://www.nesdev.org/undocumented_opcodes.txt
+
+	// read-modify-write, then an ALU operation
+	0x07: {Instruction: (*Cpu).SLO, Name: "SLO", Cycles: 5, AddressingMode: ZeroPage, Unofficial: true},
+	0x17: {Instruction: (*Cpu).SLO, Name: "SLO", Cycles: 6, AddressingMode: ZeroPageX, Unofficial: true},
+	0x0F: {Instruction: (*Cpu).SLO, Name: "SLO", Cycles: 6, AddressingMode: Absolute, Unofficial: true},
+	0x1F: {Instruction: (*Cpu).SLO, Name: "SLO", Cycles: 7, AddressingMode: AbsoluteX, Unofficial: true},
+	0x1B: {Instruction: (*Cpu).SLO, Name: "SLO", Cycles: 7, AddressingMode: AbsoluteY, Unofficial: true},
+	0x03: {Instruction: (*Cpu).SLO, Name: "SLO", Cycles: 8, AddressingMode: IndirectX, Unofficial: true},
+	0x13: {Instruction: (*Cpu).SLO, Name: "SLO", Cycles: 8, AddressingMode: IndirectY, Unofficial: true},
+	0x27: {Instruction: (*Cpu).RLA, Name: "RLA", Cycles: 5, AddressingMode: ZeroPage, Unofficial: true},
+	0x37: {Instruction: (*Cpu).RLA, Name: "RLA", Cycles: 6, AddressingMode: ZeroPageX, Unofficial: true},
+	0x2F: {Instruction: (*Cpu).RLA, Name: "RLA", Cycles: 6, AddressingMode: Absolute, Unofficial: true},
+	0x3F: {Instruction: (*Cpu).RLA, Name: "RLA", Cycles: 7, AddressingMode: AbsoluteX, Unofficial: true},
+	0x3B: {Instruction: (*Cpu).RLA, Name: "RLA", Cycles: 7, AddressingMode: AbsoluteY, Unofficial: true},
+	0x23: {Instruction: (*Cpu).RLA, Name: "RLA", Cycles: 8, AddressingMode: IndirectX, Unofficial: true},
+	0x33: {Instruction: (*Cpu).RLA, Name: "RLA", Cycles: 8, AddressingMode: IndirectY, Unofficial: true},
+	0x47: {Instruction: (*Cpu).SRE, Name: "SRE", Cycles: 5, AddressingMode: ZeroPage, Unofficial: true},
+	0x57: {Instruction: (*Cpu).SRE, Name: "SRE", Cycles: 6, AddressingMode: ZeroPageX, Unofficial: true},
+	0x4F: {Instruction: (*Cpu).SRE, Name: "SRE", Cycles: 6, AddressingMode: Absolute, Unofficial: true},
+	0x5F: {Instruction: (*Cpu).SRE, Name: "SRE", Cycles: 7, AddressingMode: AbsoluteX, Unofficial: true},
+	0x5B: {Instruction: (*Cpu).SRE, Name: "SRE", Cycles: 7, AddressingMode: AbsoluteY, Unofficial: true},
+	0x43: {Instruction: (*Cpu).SRE, Name: "SRE", Cycles: 8, AddressingMode: IndirectX, Unofficial: true},
+	0x53: {Instruction: (*Cpu).SRE, Name: "SRE", Cycles: 8, AddressingMode: IndirectY, Unofficial: true},
+	0x67: {Instruction: (*Cpu).RRA, Name: "RRA", Cycles: 5, AddressingMode: ZeroPage, Unofficial: true},
+	0x77: {Instruction: (*Cpu).RRA, Name: "RRA", Cycles: 6, AddressingMode: ZeroPageX, Unofficial: true},
+	0x6F: {Instruction: (*Cpu).RRA, Name: "RRA", Cycles: 6, AddressingMode: Absolute, Unofficial: true},
+	0x7F: {Instruction: (*Cpu).RRA, Name: "RRA", Cycles: 7, AddressingMode: AbsoluteX, Unofficial: true},
+	0x7B: {Instruction: (*Cpu).RRA, Name: "RRA", Cycles: 7, AddressingMode: AbsoluteY, Unofficial: true},
+	0x63: {Instruction: (*Cpu).RRA, Name: "RRA", Cycles: 8, AddressingMode: IndirectX, Unofficial: true},
+	0x73: {Instruction: (*Cpu).RRA, Name: "RRA", Cycles: 8, AddressingMode: IndirectY, Unofficial: true},
+	0xC7: {Instruction: (*Cpu).DCP, Name: "DCP", Cycles: 5, AddressingMode: ZeroPage, Unofficial: true},
+	0xD7: {Instruction: (*Cpu).DCP, Name: "DCP", Cycles: 6, AddressingMode: ZeroPageX, Unofficial: true},
+	0xCF: {Instruction: (*Cpu).DCP, Name: "DCP", Cycles: 6, AddressingMode: Absolute, Unofficial: true},
+	0xDF: {Instruction: (*Cpu).DCP, Name: "DCP", Cycles: 7, AddressingMode: AbsoluteX, Unofficial: true},
+	0xDB: {Instruction: (*Cpu).DCP, Name: "DCP", Cycles: 7, AddressingMode: AbsoluteY, Unofficial: true},
+	0xC3: {Instruction: (*Cpu).DCP, Name: "DCP", Cycles: 8, AddressingMode: IndirectX, Unofficial: true},
+	0xD3: {Instruction: (*Cpu).DCP, Name: "DCP", Cycles: 8, AddressingMode: IndirectY, Unofficial: true},
+	0xE7: {Instruction: (*Cpu).ISC, Name: "ISC", Cycles: 5, AddressingMode: ZeroPage, Unofficial: true},
+	0xF7: {Instruction: (*Cpu).ISC, Name: "ISC", Cycles: 6, AddressingMode: ZeroPageX, Unofficial: true},
+	0xEF: {Instruction: (*Cpu).ISC, Name: "ISC", Cycles: 6, AddressingMode: Absolute, Unofficial: true},
+	0xFF: {Instruction: (*Cpu).ISC, Name: "ISC", Cycles: 7, AddressingMode: AbsoluteX, Unofficial: true},
+	0xFB: {Instruction: (*Cpu).ISC, Name: "ISC", Cycles: 7, AddressingMode: AbsoluteY, Unofficial: true},
+	0xE3: {Instruction: (*Cpu).ISC, Name: "ISC", Cycles: 8, AddressingMode: IndirectX, Unofficial: true},
+	0xF3: {Instruction: (*Cpu).ISC, Name: "ISC", Cycles: 8, AddressingMode: IndirectY, Unofficial: true},
+
+	// load, store
+	0xA7: {Instruction: (*Cpu).LAX, Name: "LAX", Cycles: 3, AddressingMode: ZeroPage, Unofficial: true},
+	0xB7: {Instruction: (*Cpu).LAX, Name: "LAX", Cycles: 4, AddressingMode: ZeroPageY, Unofficial: true},
+	0xAF: {Instruction: (*Cpu).LAX, Name: "LAX", Cycles: 4, AddressingMode: Absolute, Unofficial: true},
+	0xBF: {Instruction: (*Cpu).LAX, Name: "LAX", Cycles: 4, AddressingMode: AbsoluteY, Unofficial: true},
+	0xA3: {Instruction: (*Cpu).LAX, Name: "LAX", Cycles: 6, AddressingMode: IndirectX, Unofficial: true},
+	0xB3: {Instruction: (*Cpu).LAX, Name: "LAX", Cycles: 5, AddressingMode: IndirectY, Unofficial: true},
+	0x87: {Instruction: (*Cpu).SAX, Name: "SAX", Cycles: 3, AddressingMode: ZeroPage, Unofficial: true},
+	0x97: {Instruction: (*Cpu).SAX, Name: "SAX", Cycles: 4, AddressingMode: ZeroPageY, Unofficial: true},
+	0x8F: {Instruction: (*Cpu).SAX, Name: "SAX", Cycles: 4, AddressingMode: Absolute, Unofficial: true},
+	0x83: {Instruction: (*Cpu).SAX, Name: "SAX", Cycles: 6, AddressingMode: IndirectX, Unofficial: true},
+
+	// immediate
+	0x0B: {Instruction: (*Cpu).ANC, Name: "ANC", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0x2B: {Instruction: (*Cpu).ANC, Name: "ANC", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0x4B: {Instruction: (*Cpu).ALR, Name: "ALR", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0x6B: {Instruction: (*Cpu).ARR, Name: "ARR", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0xCB: {Instruction: (*Cpu).AXS, Name: "AXS", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0xEB: {Instruction: (*Cpu).SBC, Name: "SBC", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+
+	// unstable; the result depends on the chip (and on its temperature)
+	0x8B: {Instruction: (*Cpu).ANE, Name: "ANE", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0xAB: {Instruction: (*Cpu).LXA, Name: "LXA", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0xBB: {Instruction: (*Cpu).LAS, Name: "LAS", Cycles: 4, AddressingMode: AbsoluteY, Unofficial: true},
+	0x9F: {Instruction: (*Cpu).SHA, Name: "SHA", Cycles: 5, AddressingMode: AbsoluteY, Unofficial: true},
+	0x93: {Instruction: (*Cpu).SHA, Name: "SHA", Cycles: 6, AddressingMode: IndirectY, Unofficial: true},
+	0x9E: {Instruction: (*Cpu).SHX, Name: "SHX", Cycles: 5, AddressingMode: AbsoluteY, Unofficial: true},
+	0x9C: {Instruction: (*Cpu).SHY, Name: "SHY", Cycles: 5, AddressingMode: AbsoluteX, Unofficial: true},
+	0x9B: {Instruction: (*Cpu).TAS, Name: "TAS", Cycles: 5, AddressingMode: AbsoluteY, Unofficial: true},
+
+	// NOPs of all sizes; those that take an operand also read it
+	0x1A: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x3A: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x5A: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x7A: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0xDA: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0xFA: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x80: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0x82: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0x89: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0xC2: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0xE2: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 2, AddressingMode: Immediate, Unofficial: true},
+	0x04: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 3, AddressingMode: ZeroPage, Unofficial: true},
+	0x44: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 3, AddressingMode: ZeroPage, Unofficial: true},
+	0x64: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 3, AddressingMode: ZeroPage, Unofficial: true},
+	0x14: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: ZeroPageX, Unofficial: true},
+	0x34: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: ZeroPageX, Unofficial: true},
+	0x54: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: ZeroPageX, Unofficial: true},
+	0x74: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: ZeroPageX, Unofficial: true},
+	0xD4: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: ZeroPageX, Unofficial: true},
+	0xF4: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: ZeroPageX, Unofficial: true},
+	0x0C: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: Absolute, Unofficial: true},
+	0x1C: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: AbsoluteX, Unofficial: true},
+	0x3C: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: AbsoluteX, Unofficial: true},
+	0x5C: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: AbsoluteX, Unofficial: true},
+	0x7C: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: AbsoluteX, Unofficial: true},
+	0xDC: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: AbsoluteX, Unofficial: true},
+	0xFC: {Instruction: (*Cpu).NOP, Name: "NOP", Cycles: 4, AddressingMode: AbsoluteX, Unofficial: true},
+
+	// jam the Cpu; see Cpu.Jam
+	0x02: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x12: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x22: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x32: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x42: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x52: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x62: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x72: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0x92: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0xB2: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0xD2: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
+	0xF2: {Instruction: (*Cpu).KIL, Name: "KIL", Cycles: 2, AddressingMode: Implied, Unofficial: true},
 }
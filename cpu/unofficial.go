@@ -0,0 +1,185 @@
+package cpu
+
+// unofficial instructions, in the same style as the official ones. most of
+// them are simply two official instructions glued together, sharing the same
+// addressing mode (and thus the same M).
+//
+// https://www.nesdev.org/wiki/CPU_unofficial_opcodes
+// https://www.nesdev.org/undocumented_opcodes.txt
+// https://www.masswerk.at/6502/6502_instruction_set.html#illegals
+
+// the 'magic' constant of ANE and LXA varies between chips (0x00, 0xee, 0xff
+// are all seen in the wild). no sane program relies on it
+const unstableMagic = 0xee
+
+// SLO - Shift Left, then OR (ASL + ORA)
+func (c *Cpu) SLO() byte {
+	c.ASL()
+	c.ORA()
+	return 0
+}
+
+// RLA - Rotate Left, then AND (ROL + AND)
+func (c *Cpu) RLA() byte {
+	c.ROL()
+	c.AND()
+	return 0
+}
+
+// SRE - Shift Right, then EOR (LSR + EOR)
+func (c *Cpu) SRE() byte {
+	c.LSR()
+	c.EOR()
+	return 0
+}
+
+// RRA - Rotate Right, then Add with Carry (ROR + ADC)
+func (c *Cpu) RRA() byte {
+	// the carry shifted out by ROR is added by ADC
+	c.ROR()
+	c.ADC()
+	return 0
+}
+
+// DCP - Decrement, then Compare (DEC + CMP)
+func (c *Cpu) DCP() byte {
+	c.DEC()
+	c.CMP()
+	return 0
+}
+
+// ISC - Increment, then Subtract with Carry (INC + SBC)
+func (c *Cpu) ISC() byte {
+	c.INC()
+	c.SBC()
+	return 0
+}
+
+// LAX - Load Accumulator and X Register (LDA + LDX)
+func (c *Cpu) LAX() byte {
+	c.Accumulator = c.M
+	c.X = c.M
+	c.setNZ(c.M)
+	return 0
+}
+
+// SAX - Store Accumulator AND X Register (A&X -> M)
+func (c *Cpu) SAX() byte {
+	// no flags affected
+	c.M = c.Accumulator & c.X
+	c.write(c.AbsAddress, c.M)
+	return 0
+}
+
+// ANC - AND, then copy Negative to Carry
+func (c *Cpu) ANC() byte {
+	c.AND()
+	c.Flags.Carry = c.Flags.Negative
+	return 0
+}
+
+// ALR - AND, then Logical Shift Right (AND + LSR A)
+func (c *Cpu) ALR() byte {
+	c.AND()
+	c.M = c.Accumulator
+	c.LSR()
+	c.Accumulator = c.M
+	return 0
+}
+
+// ARR - AND, then Rotate Right (AND + ROR A), with odd flags
+func (c *Cpu) ARR() byte {
+	c.AND()
+	c.M = c.Accumulator
+	c.ROR()
+	c.Accumulator = c.M
+
+	// the flags come from the adder, not the shifter: C is bit 6, V is
+	// bit 6 xor bit 5
+	c.Flags.Carry = c.Accumulator&0x40 > 0
+	c.Flags.Overflow = (c.Accumulator>>6)&1 != (c.Accumulator>>5)&1
+	return 0
+}
+
+// AXS - (A AND X) minus M -> X, without borrow (aka SBX)
+func (c *Cpu) AXS() byte {
+	// like CMP, except that the result is kept
+	ax := c.Accumulator & c.X
+	c.Flags.Carry = ax >= c.M
+	c.X = ax - c.M
+	c.setNZ(c.X)
+	return 0
+}
+
+// LAS - M AND Stack -> A, X, Stack
+func (c *Cpu) LAS() byte {
+	c.Stack &= c.M
+	c.Accumulator = c.Stack
+	c.X = c.Stack
+	c.setNZ(c.Stack)
+	return 0
+}
+
+// ANE - (A OR magic) AND X AND M -> A (aka XAA); unstable
+func (c *Cpu) ANE() byte {
+	c.Accumulator = (c.Accumulator | unstableMagic) & c.X & c.M
+	c.setNZ(c.Accumulator)
+	return 0
+}
+
+// LXA - (A OR magic) AND M -> A, X; unstable
+func (c *Cpu) LXA() byte {
+	c.Accumulator = (c.Accumulator | unstableMagic) & c.M
+	c.X = c.Accumulator
+	c.setNZ(c.Accumulator)
+	return 0
+}
+
+// SHA - Store A AND X AND (high byte + 1) (aka AHX); unstable
+func (c *Cpu) SHA() byte {
+	c.unstableStore(c.Accumulator&c.X, c.Y)
+	return 0
+}
+
+// SHX - Store X AND (high byte + 1); unstable
+func (c *Cpu) SHX() byte {
+	c.unstableStore(c.X, c.Y)
+	return 0
+}
+
+// SHY - Store Y AND (high byte + 1); unstable
+func (c *Cpu) SHY() byte {
+	c.unstableStore(c.Y, c.X)
+	return 0
+}
+
+// TAS - A AND X -> Stack, then SHA (aka SHS); unstable
+func (c *Cpu) TAS() byte {
+	c.Stack = c.Accumulator & c.X
+	c.unstableStore(c.Stack, c.Y)
+	return 0
+}
+
+// unstableStore writes b AND (the high byte of the unindexed address, plus 1)
+// to AbsAddress, which was indexed by i. If a page was crossed, the high byte
+// of the address is corrupted in the same way, which is the behaviour most
+// emulators (and test ROMs) agree on.
+func (c *Cpu) unstableStore(b byte, i byte) {
+	base := c.AbsAddress - uint16(i)
+	b &= byte(base>>8) + 1
+
+	addr := c.AbsAddress
+	if addr&0xff00 != base&0xff00 {
+		addr = uint16(b)<<8 | addr&0x00ff
+	}
+	c.M = b
+	c.write(addr, b)
+}
+
+// KIL - Jam the Cpu (aka JAM, HLT)
+func (c *Cpu) KIL() byte {
+	// on hardware, the Cpu stops fetching instructions until reset; what
+	// we actually do is decided by Cpu.Jam (see execute)
+	c.halted = true
+	return 0
+}
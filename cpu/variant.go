@@ -0,0 +1,137 @@
+package cpu
+
+// A Variant is a member of the 6502 family. The differences between variants
+// are small, and are handled by the Instructions themselves.
+type Variant int
+
+const (
+	// Ricoh2A03 is the Cpu of the NES (RP2A03 on NTSC, RP2A07 on PAL).
+	// It is an NMOS 6502 with the decimal mode disconnected: the Decimal
+	// flag can be set and cleared, but has no effect.
+	Ricoh2A03 Variant = iota
+	// NMOS6502 is the original MOS 6502, as used in many other machines
+	// (Apple II, Commodore 64, etc). ADC and SBC operate on BCD while
+	// the Decimal flag is set.
+	NMOS6502
+	// WDC65C02 is the CMOS 6502 by WDC. It fixes a number of bugs (e.g.
+	// JMP (ind)), adds a few Instructions and addressing modes, and turns
+	// all undefined opcodes into NOPs. The Rockwell/WDC bit instructions
+	// (RMB, SMB, BBR, BBS) and WAI/STP are not implemented.
+	//
+	// Read-modify-write instructions read their operand twice, instead of
+	// writing it back twice, and ASL, LSR, ROL and ROR abs,X take 6 cycles
+	// unless a page is crossed. Other differences in bus activity (e.g.
+	// which address is read while an index is added) are not modelled.
+	//
+	// http://www.6502.org/tutorials/65c02opcodes.html
+	WDC65C02
+)
+
+func (v Variant) String() string {
+	switch v {
+	case Ricoh2A03:
+		return "2A03"
+	case NMOS6502:
+		return "6502"
+	case WDC65C02:
+		return "65C02"
+	}
+	return "unknown"
+}
+
+// decimal reports whether ADC and SBC should operate on BCD.
+func (c *Cpu) decimal() bool {
+	return c.Flags.Decimal && c.Variant != Ricoh2A03
+}
+
+// https://www.6502.org/tutorials/decimal_mode.html#A
+//
+// in BCD, each nibble holds a decimal digit (0-9), so 0x19 is 19, not 25. the
+// result of adding (or subtracting) 2 BCD bytes is only valid if both are
+// valid BCD, but invalid inputs still produce a well-defined (if useless)
+// result, which is what the algorithms below reproduce.
+//
+// on the NMOS 6502, only the Accumulator and Carry are adjusted; N, V and Z
+// reflect some intermediate (binary) result, and are effectively undefined.
+// the 65C02 sets N and Z correctly, but takes an extra cycle to do so.
+
+// addDecimal adds b and Carry to the Accumulator in BCD.
+func (c *Cpu) addDecimal(b byte) {
+	a := c.Accumulator
+	carry := 0
+	if c.Flags.Carry {
+		carry = 1
+	}
+
+	// Z is set as if the addition were binary
+	c.Flags.Zero = a+b+byte(carry) == 0
+
+	// add the low digits, and adjust them (carrying into the high digit)
+	lo := int(a&0x0f) + int(b&0x0f) + carry
+	if lo >= 0x0a {
+		lo = (lo+0x06)&0x0f + 0x10
+	}
+
+	// N and V come from the sum of the high digits, before they are
+	// adjusted
+	sum := int(a&0xf0) + int(b&0xf0) + lo
+	signed := int(int8(a&0xf0)) + int(int8(b&0xf0)) + lo
+	c.Flags.Negative = sum&0x80 > 0
+	c.Flags.Overflow = signed < -128 || signed > 127
+
+	if sum >= 0xa0 {
+		sum += 0x60
+	}
+	c.Flags.Carry = sum >= 0x100
+	c.Accumulator = byte(sum)
+	c.fixDecimalFlags()
+}
+
+// subDecimal subtracts b and the borrow (i.e. !Carry) from the Accumulator in
+// BCD. The 65C02 adjusts the digits in a different order, which only matters
+// for invalid BCD.
+func (c *Cpu) subDecimal(b byte) {
+	a := c.Accumulator
+	borrow := 1
+	if c.Flags.Carry {
+		borrow = 0
+	}
+
+	// all flags are set as if the subtraction were binary
+	c.Accumulator = c.add(^b)
+
+	lo := int(a&0x0f) - int(b&0x0f) - borrow
+	var diff int
+	if c.Variant == WDC65C02 {
+		// sequence 4: subtract the whole bytes, adjust the high digit,
+		// then the low digit (whose borrow has already been taken
+		// from the high digit)
+		diff = int(a) - int(b) - borrow
+		if diff < 0 {
+			diff -= 0x60
+		}
+		if lo < 0 {
+			diff -= 0x06
+		}
+	} else {
+		// sequence 3: adjust the low digit, then subtract the high
+		// digits and adjust them
+		if lo < 0 {
+			lo = (lo-0x06)&0x0f - 0x10
+		}
+		diff = int(a&0xf0) - int(b&0xf0) + lo
+		if diff < 0 {
+			diff -= 0x60
+		}
+	}
+	c.Accumulator = byte(diff)
+	c.fixDecimalFlags()
+}
+
+func (c *Cpu) fixDecimalFlags() {
+	if c.Variant == WDC65C02 {
+		c.dummyRead(c.AbsAddress)
+		c.Cycles++
+		c.setNZ(c.Accumulator)
+	}
+}
@@ -0,0 +1,163 @@
+package cpu
+
+// Instructions and Opcodes added by the 65C02 (see WDC65C02).
+//
+// http://www.6502.org/tutorials/65c02opcodes.html
+// https://www.westerndesigncenter.com/wdc/documentation/w65c02s.pdf
+
+// BRA - Branch Always
+func (c *Cpu) BRA() byte {
+	c.branch(true)
+	return 0
+}
+
+// PHX - Push X Register
+func (c *Cpu) PHX() byte {
+	c.push(c.X)
+	return 0
+}
+
+// PHY - Push Y Register
+func (c *Cpu) PHY() byte {
+	c.push(c.Y)
+	return 0
+}
+
+// PLX - Pull X Register
+func (c *Cpu) PLX() byte {
+	c.dummyRead(0x0100 | uint16(c.Stack))
+	c.X = c.pull()
+	c.setNZ(c.X)
+	return 0
+}
+
+// PLY - Pull Y Register
+func (c *Cpu) PLY() byte {
+	c.dummyRead(0x0100 | uint16(c.Stack))
+	c.Y = c.pull()
+	c.setNZ(c.Y)
+	return 0
+}
+
+// STZ - Store Zero (0 -> M)
+func (c *Cpu) STZ() byte {
+	c.M = 0
+	c.write(c.AbsAddress, c.M)
+	return 0
+}
+
+// TRB - Test and Reset Bits (M &= ^A)
+func (c *Cpu) TRB() byte {
+	// Z is set like BIT; N and V are not affected
+	c.Flags.Zero = c.M&c.Accumulator == 0
+	c.M &^= c.Accumulator
+	return 0
+}
+
+// TSB - Test and Set Bits (M |= A)
+func (c *Cpu) TSB() byte {
+	c.Flags.Zero = c.M&c.Accumulator == 0
+	c.M |= c.Accumulator
+	return 0
+}
+
+// BIT #imm only sets Zero; N and V come from memory, which makes no sense for
+// an immediate operand
+func (c *Cpu) bitImmediate() byte {
+	c.Flags.Zero = c.M&c.Accumulator == 0
+	return 0
+}
+
+// the undefined opcode 0x5C reads its operand like any Absolute NOP, but then
+// keeps the bus busy for another 4 cycles
+func (c *Cpu) nopLong() byte {
+	for range 4 {
+		c.dummyRead(c.AbsAddress)
+	}
+	return 0
+}
+
+// opcodes65C02 starts from the official opcodes; unofficial ones are replaced
+// by new Instructions, or by NOPs of various sizes.
+var opcodes65C02 = func() map[byte]Opcode {
+	ops := map[byte]Opcode{}
+	for b, op := range opcodes {
+		if !op.Unofficial {
+			ops[b] = op
+		}
+	}
+
+	for b, op := range map[byte]Opcode{
+		0x6C: {Instruction: (*Cpu).JMP, Name: "JMP", Cycles: 6, AddressingMode: Indirect},
+		0x7C: {Instruction: (*Cpu).JMP, Name: "JMP", Cycles: 6, AddressingMode: AbsoluteIndirectX},
+
+		0x80: {Instruction: (*Cpu).BRA, Name: "BRA", Cycles: 2, AddressingMode: Relative},
+
+		0x1A: {Instruction: (*Cpu).INC, Name: "INC", Cycles: 2, AddressingMode: Accumulator},
+		0x3A: {Instruction: (*Cpu).DEC, Name: "DEC", Cycles: 2, AddressingMode: Accumulator},
+
+		0x89: {Instruction: (*Cpu).bitImmediate, Name: "BIT", Cycles: 2, AddressingMode: Immediate},
+		0x34: {Instruction: (*Cpu).BIT, Name: "BIT", Cycles: 4, AddressingMode: ZeroPageX},
+		0x3C: {Instruction: (*Cpu).BIT, Name: "BIT", Cycles: 4, AddressingMode: AbsoluteX},
+
+		0xDA: {Instruction: (*Cpu).PHX, Name: "PHX", Cycles: 3, AddressingMode: Implied},
+		0x5A: {Instruction: (*Cpu).PHY, Name: "PHY", Cycles: 3, AddressingMode: Implied},
+		0xFA: {Instruction: (*Cpu).PLX, Name: "PLX", Cycles: 4, AddressingMode: Implied},
+		0x7A: {Instruction: (*Cpu).PLY, Name: "PLY", Cycles: 4, AddressingMode: Implied},
+
+		0x64: {Instruction: (*Cpu).STZ, Name: "STZ", Cycles: 3, AddressingMode: ZeroPage},
+		0x74: {Instruction: (*Cpu).STZ, Name: "STZ", Cycles: 4, AddressingMode: ZeroPageX},
+		0x9C: {Instruction: (*Cpu).STZ, Name: "STZ", Cycles: 4, AddressingMode: Absolute},
+		0x9E: {Instruction: (*Cpu).STZ, Name: "STZ", Cycles: 5, AddressingMode: AbsoluteX},
+
+		0x14: {Instruction: (*Cpu).TRB, Name: "TRB", Cycles: 5, AddressingMode: ZeroPage},
+		0x1C: {Instruction: (*Cpu).TRB, Name: "TRB", Cycles: 6, AddressingMode: Absolute},
+		0x04: {Instruction: (*Cpu).TSB, Name: "TSB", Cycles: 5, AddressingMode: ZeroPage},
+		0x0C: {Instruction: (*Cpu).TSB, Name: "TSB", Cycles: 6, AddressingMode: Absolute},
+
+		// (zp)
+		0x12: {Instruction: (*Cpu).ORA, Name: "ORA", Cycles: 5, AddressingMode: ZeroPageIndirect},
+		0x32: {Instruction: (*Cpu).AND, Name: "AND", Cycles: 5, AddressingMode: ZeroPageIndirect},
+		0x52: {Instruction: (*Cpu).EOR, Name: "EOR", Cycles: 5, AddressingMode: ZeroPageIndirect},
+		0x72: {Instruction: (*Cpu).ADC, Name: "ADC", Cycles: 5, AddressingMode: ZeroPageIndirect},
+		0x92: {Instruction: (*Cpu).STA, Name: "STA", Cycles: 5, AddressingMode: ZeroPageIndirect},
+		0xB2: {Instruction: (*Cpu).LDA, Name: "LDA", Cycles: 5, AddressingMode: ZeroPageIndirect},
+		0xD2: {Instruction: (*Cpu).CMP, Name: "CMP", Cycles: 5, AddressingMode: ZeroPageIndirect},
+		0xF2: {Instruction: (*Cpu).SBC, Name: "SBC", Cycles: 5, AddressingMode: ZeroPageIndirect},
+	} {
+		ops[b] = op
+	}
+
+	// ASL, LSR, ROL and ROR abs,X only take the index cycle on a page
+	// cross, like loads (INC and DEC abs,X still always take 7 cycles)
+	for _, b := range []byte{0x1E, 0x3E, 0x5E, 0x7E} {
+		op := ops[b]
+		op.Cycles = 6
+		op.fastIndex = true
+		ops[b] = op
+	}
+
+	// the remaining (undefined) opcodes are NOPs, which still read their
+	// operands. unlike the unofficial opcodes of the 6502, they are
+	// documented, and safe to use
+	nop := func(cycles byte, mode AddressingMode) Opcode {
+		return Opcode{Instruction: (*Cpu).NOP, Name: "NOP", Cycles: cycles, AddressingMode: mode}
+	}
+	for _, b := range []byte{0x02, 0x22, 0x42, 0x62, 0x82, 0xC2, 0xE2} {
+		ops[b] = nop(2, Immediate)
+	}
+	ops[0x44] = nop(3, ZeroPage)
+	for _, b := range []byte{0x54, 0xD4, 0xF4} {
+		ops[b] = nop(4, ZeroPageX)
+	}
+	ops[0x5C] = Opcode{Instruction: (*Cpu).nopLong, Name: "NOP", Cycles: 8, AddressingMode: Absolute}
+	ops[0xDC] = nop(4, Absolute)
+	ops[0xFC] = nop(4, Absolute)
+	for i := range 256 {
+		if _, ok := ops[byte(i)]; !ok {
+			// x3, x7, xB, xF
+			ops[byte(i)] = nop(1, Implied)
+		}
+	}
+	return ops
+}()
@@ -11,5 +11,95 @@ package main
 
 // https://www.ascii-code.com/
 
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A command is a subcommand of gone, e.g. `gone run`.
+type command struct {
+	args  string // positional args, for usage
+	help  string
+	flags func(fs *flag.FlagSet, o *options)
+	run   func(o *options, args []string) error
+}
+
+var commands = map[string]command{
+	"run":    {args: "<rom>", help: "play a game in the terminal", flags: runFlags, run: runRom},
+	"debug":  {args: "<rom|hexfile>", help: "step through a program in the debugger", flags: debugFlags, run: debug},
+	"disasm": {args: "<rom>", help: "disassemble the PRG-ROM of a cartridge", run: disasm},
+	"info":   {args: "<rom>", help: "show the header of a cartridge", run: info},
+	"test":   {args: "<rom>", help: "run a test ROM headlessly, and report its result", flags: testFlags, run: testRom},
+}
+
+// options holds the flags of all commands; each command only registers the
+// ones it uses.
+type options struct {
+	region string
+	scale  float64
+	log    string
+
+	frames     int    // run, test
+	screenshot string // run
+	wav        string // run
+	headless   bool   // run
+}
+
+func addRegion(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.region, "region", "auto", "timing: auto (from the header), ntsc, pal or dendy")
+}
+
+func addLog(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.log, "log", filepath.Join(os.TempDir(), "gone.log"), "file to log to")
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gone <command> [flags] <file>")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range []string{"run", "debug", "disasm", "info", "test"} {
+		cmd := commands[name]
+		fmt.Fprintf(os.Stderr, "  %-24s %s\n", name+" "+cmd.args, cmd.help)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run `gone <command> -h` for the flags of each command")
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" && !strings.HasPrefix(name, "-") {
+			fmt.Fprintf(os.Stderr, "gone: unknown command %q\n\n", name)
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("gone "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gone %s [flags] %s\n\n%s\n\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	var o options
+	if cmd.flags != nil {
+		cmd.flags(fs, &o)
+	}
+	_ = fs.Parse(os.Args[2:]) // exits on error
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(&o, fs.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "gone:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,180 @@
+// Package mapper implements the bank switching hardware found in NES
+// cartridges.
+//
+// The CPU can only address 32 kB of PRG-ROM (0x8000-0xffff), and the PPU only
+// 8 kB of CHR (0x0000-0x1fff), but most games are much larger than that. A
+// mapper (a chip on the cartridge) decides which part ('bank') of the ROM is
+// visible at any given time. The game selects banks by writing to the mapper,
+// typically at addresses that would otherwise be read-only ROM.
+
+package mapper
+
+import (
+	"fmt"
+
+	"gone/cartridge"
+	"gone/mem"
+)
+
+// https://www.nesdev.org/wiki/Mapper
+
+// A Mapper connects a Cartridge to both Buses. On the CPU Bus, a Mapper is a
+// mem.Device that claims the entire cartridge space (0x4020-0xffff). On the
+// PPU Bus, it is the mem.Chr that provides the pattern tables (0x0000-0x1fff)
+// and decides the nametable mirroring.
+//
+// Writes to CHR are ignored, unless the cartridge has CHR-RAM. Unlike the
+// value declared in the header, Mirroring may change at runtime.
+type Mapper interface {
+	mem.Device
+	mem.Chr
+}
+
+// A ScanlineCounter is a Mapper that counts scanlines rendered by the PPU, in
+// order to interrupt the Cpu mid-frame (e.g. for split screen effects).
+type ScanlineCounter interface {
+	// Scanline must be called once per scanline, while rendering is
+	// enabled.
+	Scanline()
+}
+
+// An Interrupter is a Mapper that can interrupt the Cpu.
+type Interrupter interface {
+	// SetIrq connects the IRQ output of the Mapper to irq, which is called
+	// whenever the output changes (typically Cpu.SetIRQ).
+	SetIrq(irq func(active bool))
+}
+
+// New returns the Mapper declared by the cartridge header. The PRG-ROM (and
+// the CHR-ROM, if any) must be a whole number of banks; NES 2.0 headers can
+// declare smaller sizes, which no mapper supports.
+func New(cart *cartridge.Cartridge) (Mapper, error) {
+	if len(cart.PrgRom) == 0 || len(cart.PrgRom)%prgBankSize != 0 {
+		return nil, fmt.Errorf("invalid PRG-ROM size: %d bytes (must be a multiple of %d)", len(cart.PrgRom), prgBankSize)
+	}
+	if len(cart.ChrRom)%chrBankSize != 0 {
+		return nil, fmt.Errorf("invalid CHR-ROM size: %d bytes (must be a multiple of %d)", len(cart.ChrRom), chrBankSize)
+	}
+
+	switch cart.Mapper {
+	case 0:
+		return newNrom(cart), nil
+	case 1:
+		return newMmc1(cart), nil
+	case 2:
+		return newUxrom(cart), nil
+	case 3:
+		return newCnrom(cart), nil
+	case 4:
+		return newMmc3(cart), nil
+	default:
+		return nil, fmt.Errorf("unsupported mapper: %d", cart.Mapper)
+	}
+}
+
+const (
+	prgBankSize = 8 * 1024 // smallest unit of PRG switching
+	chrBankSize = 1 * 1024 // smallest unit of CHR switching
+	prgRamSize  = 8 * 1024
+)
+
+// base implements the parts common to all Mappers. The CPU and PPU address
+// spaces are divided into 4 PRG slots of 8 kB (0x8000-0xffff) and 8 CHR slots
+// of 1 kB (0x0000-0x1fff) respectively. Each Mapper only needs to decide
+// which bank is placed in which slot(s); base takes care of the rest.
+type base struct {
+	prg    []byte
+	chr    []byte // CHR-ROM or CHR-RAM
+	chrRam bool
+	prgRam []byte // 0x6000-0x7fff
+
+	prgSlots [4]int // offsets into prg
+	chrSlots [8]int // offsets into chr
+
+	mirroring cartridge.Mirroring
+}
+
+func newBase(cart *cartridge.Cartridge) base {
+	b := base{
+		prg:       cart.PrgRom,
+		chr:       cart.ChrRom,
+		prgRam:    make([]byte, prgRamSize),
+		mirroring: cart.Mirroring,
+	}
+	if len(b.chr) == 0 {
+		b.chr = make([]byte, max(cart.ChrRamSize+cart.ChrNvramSize, 8*1024))
+		b.chrRam = true
+	}
+	if cart.Trainer {
+		copy(b.prgRam[0x1000:], cart.TrainerData)
+	}
+
+	// by default, the first 32 kB of PRG (which may be mirrored), and the
+	// first 8 kB of CHR
+	b.setPrg(0, 32*1024, 0)
+	b.setChr(0, 8*1024, 0)
+	return b
+}
+
+// setPrg places a bank of the given size (a multiple of 8 kB) starting at the
+// given slot. Negative banks count from the end, e.g. -1 is the last bank.
+// Banks beyond the end of the ROM wrap around, as on real hardware (where the
+// upper address lines are simply not connected).
+func (b *base) setPrg(slot int, size int, bank int) {
+	banks := max(len(b.prg)/size, 1)
+	bank = ((bank % banks) + banks) % banks
+	for i := range size / prgBankSize {
+		b.prgSlots[slot+i] = (bank*size + i*prgBankSize) % len(b.prg)
+	}
+}
+
+// setChr is like setPrg, but for CHR, in multiples of 1 kB.
+func (b *base) setChr(slot int, size int, bank int) {
+	banks := max(len(b.chr)/size, 1)
+	bank = ((bank % banks) + banks) % banks
+	for i := range size / chrBankSize {
+		b.chrSlots[slot+i] = (bank*size + i*chrBankSize) % len(b.chr)
+	}
+}
+
+func (b *base) Range() (uint16, uint16) { return 0x4020, 0xffff }
+
+func (b *base) Read(addr uint16, readonly bool) byte {
+	switch {
+	case addr >= 0x8000:
+		slot := (addr - 0x8000) / prgBankSize
+		return b.prg[b.prgSlots[slot]+int(addr%prgBankSize)]
+	case addr >= 0x6000:
+		return b.prgRam[addr-0x6000]
+	default:
+		// expansion area, not connected. open bus usually holds the
+		// high byte of the address, since that was the last byte read
+		// by the Cpu
+		return byte(addr >> 8)
+	}
+}
+
+// Write handles writes to PRG-RAM. Writes to ROM are discarded; Mappers
+// intercept these writes before calling Write.
+func (b *base) Write(addr uint16, data byte) {
+	if addr >= 0x6000 && addr < 0x8000 {
+		b.prgRam[addr-0x6000] = data
+	}
+}
+
+func (b *base) ReadChr(addr uint16) byte {
+	addr &= 0x1fff
+	slot := addr / chrBankSize
+	return b.chr[b.chrSlots[slot]+int(addr%chrBankSize)]
+}
+
+func (b *base) WriteChr(addr uint16, data byte) {
+	if !b.chrRam {
+		return
+	}
+	addr &= 0x1fff
+	slot := addr / chrBankSize
+	b.chr[b.chrSlots[slot]+int(addr%chrBankSize)] = data
+}
+
+func (b *base) Mirroring() cartridge.Mirroring { return b.mirroring }
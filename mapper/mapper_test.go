@@ -0,0 +1,199 @@
+package mapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gone/cartridge"
+	"gone/mem"
+)
+
+// newCart returns a cartridge whose PRG and CHR banks (of the given sizes)
+// are each filled with their own bank number.
+func newCart(mapper uint16, prgBanks int, prgBank int, chrBanks int, chrBank int) *cartridge.Cartridge {
+	cart := &cartridge.Cartridge{Header: cartridge.Header{Mapper: mapper}}
+	for i := range prgBanks {
+		for range prgBank {
+			cart.PrgRom = append(cart.PrgRom, byte(i))
+		}
+	}
+	for i := range chrBanks {
+		for range chrBank {
+			cart.ChrRom = append(cart.ChrRom, byte(i))
+		}
+	}
+	cart.PrgRomSize = len(cart.PrgRom)
+	cart.ChrRomSize = len(cart.ChrRom)
+	return cart
+}
+
+func TestNew(t *testing.T) {
+	for _, m := range []uint16{0, 1, 2, 3, 4} {
+		_, err := New(newCart(m, 2, 16*1024, 1, 8*1024))
+		assert.NoError(t, err)
+	}
+	_, err := New(newCart(5, 2, 16*1024, 1, 8*1024))
+	assert.EqualError(t, err, "unsupported mapper: 5")
+
+	// ROMs that are not a whole number of banks would be read out of
+	// bounds
+	_, err = New(newCart(0, 1, 48, 1, 8*1024))
+	assert.EqualError(t, err, "invalid PRG-ROM size: 48 bytes (must be a multiple of 8192)")
+	_, err = New(newCart(0, 0, 0, 1, 8*1024))
+	assert.EqualError(t, err, "invalid PRG-ROM size: 0 bytes (must be a multiple of 8192)")
+	_, err = New(newCart(0, 2, 16*1024, 1, 1000))
+	assert.EqualError(t, err, "invalid CHR-ROM size: 1000 bytes (must be a multiple of 1024)")
+
+	// mappers claim the entire cartridge space
+	m, _ := New(newCart(0, 2, 16*1024, 1, 8*1024))
+	b := mem.Bus{}
+	assert.NoError(t, b.Register(m))
+}
+
+func TestNrom(t *testing.T) {
+	m, _ := New(newCart(0, 1, 16*1024, 1, 8*1024))
+	m.Write(0xc000, 0xff)
+	assert.Equal(t, m.Read(0x8000, false), byte(0))
+	assert.Equal(t, m.Read(0xc000, false), byte(0)) // mirrored
+
+	m.Write(0x6000, 0x42)
+	assert.Equal(t, m.Read(0x6000, false), byte(0x42))
+
+	// CHR-ROM is read-only
+	m.WriteChr(0x0000, 0x42)
+	assert.Equal(t, m.ReadChr(0x0000), byte(0))
+
+	// CHR-RAM is not
+	m, _ = New(newCart(0, 2, 16*1024, 0, 0))
+	m.WriteChr(0x1fff, 0x42)
+	assert.Equal(t, m.ReadChr(0x1fff), byte(0x42))
+	assert.Equal(t, m.Read(0xc000, false), byte(1))
+}
+
+func TestUxrom(t *testing.T) {
+	m, _ := New(newCart(2, 8, 16*1024, 0, 0))
+	assert.Equal(t, m.Read(0x8000, false), byte(0))
+	assert.Equal(t, m.Read(0xc000, false), byte(7))
+	m.Write(0x8000, 3)
+	assert.Equal(t, m.Read(0x8000, false), byte(3))
+	assert.Equal(t, m.Read(0xbfff, false), byte(3))
+	assert.Equal(t, m.Read(0xc000, false), byte(7))
+}
+
+func TestCnrom(t *testing.T) {
+	m, _ := New(newCart(3, 2, 16*1024, 4, 8*1024))
+	assert.Equal(t, m.ReadChr(0x0000), byte(0))
+	m.Write(0xffff, 2)
+	assert.Equal(t, m.ReadChr(0x0000), byte(2))
+	assert.Equal(t, m.ReadChr(0x1fff), byte(2))
+	m.Write(0xffff, 5) // wraps around
+	assert.Equal(t, m.ReadChr(0x0000), byte(1))
+}
+
+func TestMmc1(t *testing.T) {
+	m, _ := New(newCart(1, 8, 16*1024, 8, 4*1024))
+
+	// writes 5 bits, LSB first
+	write := func(addr uint16, data byte) {
+		for range 5 {
+			m.Write(addr, data&1)
+			data >>= 1
+		}
+	}
+
+	// power-up: last bank fixed at 0xc000
+	assert.Equal(t, m.Read(0xc000, false), byte(7))
+
+	write(0xe000, 5)
+	assert.Equal(t, m.Read(0x8000, false), byte(5))
+	assert.Equal(t, m.Read(0xc000, false), byte(7))
+
+	// vertical mirroring, PRG mode 2, CHR mode 1
+	write(0x8000, 0b1_10_10)
+	assert.Equal(t, m.Mirroring(), cartridge.Vertical)
+	assert.Equal(t, m.Read(0x8000, false), byte(0))
+	assert.Equal(t, m.Read(0xc000, false), byte(5))
+
+	write(0xa000, 3)
+	write(0xc000, 6)
+	assert.Equal(t, m.ReadChr(0x0000), byte(3))
+	assert.Equal(t, m.ReadChr(0x1000), byte(6))
+
+	// a write with bit 7 set resets the shift register
+	m.Write(0x8000, 1)
+	m.Write(0x8000, 0x80)
+	write(0x8000, 0b0_11_00)
+	assert.Equal(t, m.Mirroring(), cartridge.SingleScreenLower)
+	assert.Equal(t, m.ReadChr(0x1000), byte(3)) // 8 kB mode
+}
+
+func TestMmc3(t *testing.T) {
+	m, _ := New(newCart(4, 16, 8*1024, 64, 1*1024))
+
+	// R6=3, R7=4
+	m.Write(0x8000, 6)
+	m.Write(0x8001, 3)
+	m.Write(0x8000, 7)
+	m.Write(0x8001, 4)
+	assert.Equal(t, m.Read(0x8000, false), byte(3))
+	assert.Equal(t, m.Read(0xa000, false), byte(4))
+	assert.Equal(t, m.Read(0xc000, false), byte(14))
+	assert.Equal(t, m.Read(0xe000, false), byte(15))
+
+	// PRG mode 1 swaps 0x8000 and 0xc000
+	m.Write(0x8000, 0x40)
+	assert.Equal(t, m.Read(0x8000, false), byte(14))
+	assert.Equal(t, m.Read(0xc000, false), byte(3))
+
+	// R0 (2 kB) = 10, R2 (1 kB) = 20
+	m.Write(0x8000, 0)
+	m.Write(0x8001, 10)
+	m.Write(0x8000, 2)
+	m.Write(0x8001, 20)
+	assert.Equal(t, m.ReadChr(0x0000), byte(10))
+	assert.Equal(t, m.ReadChr(0x0400), byte(11))
+	assert.Equal(t, m.ReadChr(0x1000), byte(20))
+
+	// CHR A12 inversion
+	m.Write(0x8000, 0x80)
+	assert.Equal(t, m.ReadChr(0x0000), byte(20))
+	assert.Equal(t, m.ReadChr(0x1000), byte(10))
+
+	m.Write(0xa000, 1)
+	assert.Equal(t, m.Mirroring(), cartridge.Horizontal)
+}
+
+func TestMmc3Irq(t *testing.T) {
+	m, _ := New(newCart(4, 16, 8*1024, 64, 1*1024))
+	var irqs int
+	m.(Interrupter).SetIrq(func(active bool) {
+		if active {
+			irqs++
+		}
+	})
+	counter := m.(ScanlineCounter)
+
+	m.Write(0xc000, 2) // latch
+	m.Write(0xc001, 0) // reload
+	m.Write(0xe001, 0) // enable
+
+	counter.Scanline() // reload to 2
+	counter.Scanline() // 1
+	assert.Equal(t, irqs, 0)
+	counter.Scanline() // 0
+	assert.Equal(t, irqs, 1)
+	// the IRQ is held until acknowledged
+	m.Write(0xe000, 0)
+	m.Write(0xe001, 0)
+	counter.Scanline() // reload to 2
+	counter.Scanline()
+	counter.Scanline()
+	assert.Equal(t, irqs, 2)
+
+	m.Write(0xe000, 0) // disable
+	counter.Scanline()
+	counter.Scanline()
+	counter.Scanline()
+	assert.Equal(t, irqs, 2)
+}
@@ -0,0 +1,113 @@
+package mapper
+
+import "gone/cartridge"
+
+// https://www.nesdev.org/wiki/MMC1
+
+// mmc1 (mapper 1) is configured through a 5-bit shift register: the Cpu
+// writes one bit at a time (bit 0 of each write to 0x8000-0xffff), and on the
+// 5th write, the full value is copied into one of 4 internal registers,
+// selected by the address of that 5th write.
+type mmc1 struct {
+	base
+
+	shift  byte // bits are shifted in from the left
+	writes byte // number of bits in shift
+
+	control byte // 0x8000-0x9fff; mirroring, PRG mode, CHR mode
+	chr0    byte // 0xa000-0xbfff
+	chr1    byte // 0xc000-0xdfff
+	prgBank byte // 0xe000-0xffff
+}
+
+func newMmc1(cart *cartridge.Cartridge) *mmc1 {
+	m := &mmc1{base: newBase(cart)}
+	m.control = 0x0c // PRG mode 3 on power-up, i.e. last bank fixed
+	m.update()
+	return m
+}
+
+func (m *mmc1) Write(addr uint16, data byte) {
+	if addr < 0x8000 {
+		if m.prgBank&0x10 == 0 { // PRG-RAM enabled
+			m.base.Write(addr, data)
+		}
+		return
+	}
+
+	if data&0x80 > 0 {
+		// reset
+		m.shift = 0
+		m.writes = 0
+		m.control |= 0x0c
+		m.update()
+		return
+	}
+
+	m.shift = m.shift>>1 | (data&1)<<4
+	m.writes++
+	if m.writes < 5 {
+		return
+	}
+
+	switch addr & 0xe000 {
+	case 0x8000:
+		m.control = m.shift
+	case 0xa000:
+		m.chr0 = m.shift
+	case 0xc000:
+		m.chr1 = m.shift
+	case 0xe000:
+		m.prgBank = m.shift
+	}
+	m.shift = 0
+	m.writes = 0
+	m.update()
+}
+
+func (m *mmc1) Read(addr uint16, readonly bool) byte {
+	if addr >= 0x6000 && addr < 0x8000 && m.prgBank&0x10 > 0 {
+		return byte(addr >> 8) // PRG-RAM disabled; open bus
+	}
+	return m.base.Read(addr, readonly)
+}
+
+// update recomputes the bank slots from the internal registers.
+func (m *mmc1) update() {
+	switch m.control & 0x03 {
+	case 0:
+		m.mirroring = cartridge.SingleScreenLower
+	case 1:
+		m.mirroring = cartridge.SingleScreenUpper
+	case 2:
+		m.mirroring = cartridge.Vertical
+	case 3:
+		m.mirroring = cartridge.Horizontal
+	}
+
+	// on 512 kB boards (SUROM), bit 4 of the CHR registers selects the
+	// 256 kB half of PRG-ROM
+	prg := int(m.prgBank & 0x0f)
+	outer := 0
+	if len(m.prg) > 256*1024 {
+		outer = int(m.chr0 & 0x10) // 0 or 16 (banks of 16 kB)
+	}
+
+	switch (m.control >> 2) & 0x03 {
+	case 0, 1: // 32 kB; low bit ignored
+		m.setPrg(0, 32*1024, (outer|prg)>>1)
+	case 2: // first bank fixed at 0x8000
+		m.setPrg(0, 16*1024, outer)
+		m.setPrg(2, 16*1024, outer|prg)
+	case 3: // last bank fixed at 0xc000
+		m.setPrg(0, 16*1024, outer|prg)
+		m.setPrg(2, 16*1024, outer|0x0f)
+	}
+
+	if m.control&0x10 == 0 { // 8 kB; low bit ignored
+		m.setChr(0, 8*1024, int(m.chr0>>1))
+	} else {
+		m.setChr(0, 4*1024, int(m.chr0))
+		m.setChr(4, 4*1024, int(m.chr1))
+	}
+}
@@ -0,0 +1,153 @@
+package mapper
+
+import "gone/cartridge"
+
+// https://www.nesdev.org/wiki/MMC3
+
+// mmc3 (mapper 4) switches PRG in 8 kB banks and CHR in 1 kB/2 kB banks,
+// through 8 bank registers (R0-R7). It also contains a scanline counter that
+// can interrupt the Cpu.
+//
+// Registers are selected by address range (0x8000, 0xa000, 0xc000, 0xe000)
+// and parity (even/odd).
+type mmc3 struct {
+	base
+
+	target  byte    // the bank register (R0-R7) to update on the next odd write to 0x8000-0x9fff
+	prgMode byte    // if 1, 0x8000 and 0xc000 are swapped
+	chrMode byte    // if 1, 0x0000 and 0x1000 are swapped
+	banks   [8]byte // R0-R7
+
+	ramEnabled   bool
+	ramProtected bool
+
+	fourScreen bool // mirroring is fixed by the cartridge
+
+	irqLatch   byte // value to reload the counter with
+	irqCounter byte
+	irqReload  bool // if true, the counter is reloaded on the next clock
+	irqEnabled bool
+	irqPending bool // held until acknowledged
+	irq        func(active bool)
+}
+
+func newMmc3(cart *cartridge.Cartridge) *mmc3 {
+	m := &mmc3{
+		base:       newBase(cart),
+		ramEnabled: true,
+		fourScreen: cart.Mirroring == cartridge.FourScreen,
+	}
+	m.update()
+	return m
+}
+
+func (m *mmc3) SetIrq(irq func(active bool)) { m.irq = irq }
+
+func (m *mmc3) setIrq(pending bool) {
+	if pending != m.irqPending {
+		m.irqPending = pending
+		if m.irq != nil {
+			m.irq(pending)
+		}
+	}
+}
+
+func (m *mmc3) Read(addr uint16, readonly bool) byte {
+	if addr >= 0x6000 && addr < 0x8000 && !m.ramEnabled {
+		return byte(addr >> 8) // open bus
+	}
+	return m.base.Read(addr, readonly)
+}
+
+func (m *mmc3) Write(addr uint16, data byte) {
+	if addr < 0x8000 {
+		if m.ramEnabled && !m.ramProtected {
+			m.base.Write(addr, data)
+		}
+		return
+	}
+
+	even := addr%2 == 0
+	switch addr & 0xe000 {
+	case 0x8000:
+		if even {
+			m.target = data & 0x07
+			m.prgMode = (data >> 6) & 1
+			m.chrMode = (data >> 7) & 1
+		} else {
+			m.banks[m.target] = data
+		}
+		m.update()
+
+	case 0xa000:
+		if even {
+			if !m.fourScreen {
+				m.mirroring = cartridge.Vertical
+				if data&1 > 0 {
+					m.mirroring = cartridge.Horizontal
+				}
+			}
+		} else {
+			m.ramEnabled = data&0x80 > 0
+			m.ramProtected = data&0x40 > 0
+		}
+
+	case 0xc000:
+		if even {
+			m.irqLatch = data
+		} else {
+			m.irqCounter = 0
+			m.irqReload = true
+		}
+
+	case 0xe000:
+		// disabling also acknowledges any pending interrupt
+		m.irqEnabled = !even
+		if even {
+			m.setIrq(false)
+		}
+	}
+}
+
+// Scanline clocks the IRQ counter. On hardware, the counter is clocked by
+// rising edges of PPU A12, which (with typical settings) happen once per
+// scanline, during sprite pattern fetches.
+func (m *mmc3) Scanline() {
+	if m.irqCounter == 0 || m.irqReload {
+		m.irqCounter = m.irqLatch
+		m.irqReload = false
+	} else {
+		m.irqCounter--
+	}
+	if m.irqCounter == 0 && m.irqEnabled {
+		m.setIrq(true)
+	}
+}
+
+// update recomputes the bank slots from the bank registers.
+func (m *mmc3) update() {
+	// PRG: R6 and R7 are switchable, the second-last bank is fixed at
+	// either 0x8000 or 0xc000, the last bank is always fixed at 0xe000
+	if m.prgMode == 0 {
+		m.setPrg(0, prgBankSize, int(m.banks[6]&0x3f))
+		m.setPrg(2, prgBankSize, -2)
+	} else {
+		m.setPrg(0, prgBankSize, -2)
+		m.setPrg(2, prgBankSize, int(m.banks[6]&0x3f))
+	}
+	m.setPrg(1, prgBankSize, int(m.banks[7]&0x3f))
+	m.setPrg(3, prgBankSize, -1)
+
+	// CHR: R0 and R1 select 2 kB banks (low bit ignored), R2-R5 select
+	// 1 kB banks
+	lo, hi := 0, 4
+	if m.chrMode == 1 {
+		lo, hi = 4, 0
+	}
+	m.setChr(lo+0, 2*chrBankSize, int(m.banks[0]>>1))
+	m.setChr(lo+2, 2*chrBankSize, int(m.banks[1]>>1))
+	m.setChr(hi+0, chrBankSize, int(m.banks[2]))
+	m.setChr(hi+1, chrBankSize, int(m.banks[3]))
+	m.setChr(hi+2, chrBankSize, int(m.banks[4]))
+	m.setChr(hi+3, chrBankSize, int(m.banks[5]))
+}
@@ -0,0 +1,50 @@
+package mapper
+
+import "gone/cartridge"
+
+// https://www.nesdev.org/wiki/NROM
+// https://www.nesdev.org/wiki/UxROM
+// https://www.nesdev.org/wiki/INES_Mapper_003
+
+// nrom (mapper 0) has no bank switching at all. 16 kB of PRG-ROM is mirrored
+// in 0x8000-0xbfff and 0xc000-0xffff.
+type nrom struct{ base }
+
+func newNrom(cart *cartridge.Cartridge) *nrom {
+	return &nrom{base: newBase(cart)}
+}
+
+// uxrom (mapper 2) switches the first 16 kB of PRG-ROM; the last 16 kB is
+// fixed. CHR is usually RAM.
+type uxrom struct{ base }
+
+func newUxrom(cart *cartridge.Cartridge) *uxrom {
+	m := &uxrom{base: newBase(cart)}
+	m.setPrg(0, 16*1024, 0)
+	m.setPrg(2, 16*1024, -1)
+	return m
+}
+
+func (m *uxrom) Write(addr uint16, data byte) {
+	if addr < 0x8000 {
+		m.base.Write(addr, data)
+		return
+	}
+	m.setPrg(0, 16*1024, int(data))
+}
+
+// cnrom (mapper 3) switches the entire 8 kB of CHR-ROM; PRG-ROM is fixed, as
+// in nrom.
+type cnrom struct{ base }
+
+func newCnrom(cart *cartridge.Cartridge) *cnrom {
+	return &cnrom{base: newBase(cart)}
+}
+
+func (m *cnrom) Write(addr uint16, data byte) {
+	if addr < 0x8000 {
+		m.base.Write(addr, data)
+		return
+	}
+	m.setChr(0, 8*1024, int(data))
+}
@@ -122,3 +122,9 @@ func Flip(b byte, start byteIndex, end byteIndex) byte {
 	}
 	return b
 }
+
+// Word concatenates two bytes into a 2-byte word (e.g. a memory address). The
+// first byte becomes the high byte (page), the second the low byte (column).
+func Word(page byte, col byte) uint16 {
+	return uint16(page)<<8 | uint16(col)
+}
@@ -1,41 +1,226 @@
 package mem
 
+import "fmt"
+
 // A Bus is the central (global) object that connects multiple 'hardware'
 // components together, enabling communication between them. Each Bus has an
 // independent memory layout that begins at 0x0000.
 //
 // In the NES, there are 2 Buses. One has 64 kB, responsible for CPU, memory,
-// audio and cartridge (0x0000-0xffff). The other has 8 (?) kB, responsible for
-// graphics (0x2000-0x3fff?).
+// audio and cartridge (0x0000-0xffff). The other has 16 kB, responsible for
+// graphics (0x0000-0x3fff).
 //
 // One or more components (structs) can be connected to a Bus by means of a
-// pointer; e.g. Cpu.Bus = &Bus{}.
+// pointer; e.g. Cpu.Bus = &Bus{}. The Bus itself only owns the 2 kB of
+// internal RAM; every other region must be claimed by a Device, via Register.
 type Bus struct {
-	// no divisions/mirroring of memory yet; not meant to be used for now
-	FakeRam [64 * 1024]byte // 64 kB (0xffff), zeroed on init
+	Ram [2 * 1024]byte // 0x0000-0x07ff, zeroed on init
+
+	// Err records the first access to an address that was not claimed by
+	// any Device (an *UnmappedError). It is never cleared by the Bus
+	// itself.
+	Err error
+
+	devices []Device
+
+	// the last byte that passed through the Bus. reading from an
+	// unclaimed address returns this value ('open bus')
+	data byte
 }
 
-// CPU     MEM     APU     CART
-//  |       |       |       |
-//  |       |0000   |4000   |4020
-//  |       |07ff   |4017   |ffff
-//  |------------------------------------ BUS 1
+// CPU     MEM     PPU     APU     CART
+//  |       |       |       |       |
+//  |       |0000   |2000   |4000   |4020
+//  |       |1fff   |3fff   |401f   |ffff
+//  |-------------------------------------------- BUS 1
 //  |
 // PPU     GFX     VRAM    PALETTE
 //  |       |       |       |
-//  |       |       |       |
-//  |       |       |       |
-//  |------------------------------------ BUS 2
+//  |       |0000   |2000   |3f00
+//  |       |1fff   |3eff   |3fff
+//  |-------------------------------------------- BUS 2
+
+// https://www.nesdev.org/wiki/CPU_memory_map
+
+// A Device is a hardware component that responds to reads and writes in a
+// region of a Bus. The addr passed to a Device is absolute (i.e. not relative
+// to the start of its region), with any mirroring already resolved.
+//
+// Typical Devices are the PPU (0x2000-0x2007), the APU and I/O registers
+// (0x4000-0x401f), and the cartridge (0x4020-0xffff), but any range outside
+// internal RAM can be claimed, e.g. a serial port for test programs.
+type Device interface {
+	// Range returns the inclusive range of addresses claimed by the
+	// Device. The range is fixed for the lifetime of the Device.
+	Range() (start uint16, end uint16)
 
+	// Read returns the byte at addr. Some Devices change state when read
+	// (e.g. the PPU clears its vblank flag, a controller shifts out its
+	// next button); if readonly is true, the Device must not do so.
+	Read(addr uint16, readonly bool) byte
+
+	Write(addr uint16, data byte)
+}
+
+// An UnmappedError describes an access to an address that no Device has
+// claimed.
+type UnmappedError struct {
+	Addr  uint16
+	Write bool
+}
+
+func (e *UnmappedError) Error() string {
+	if e.Write {
+		return fmt.Sprintf("unmapped write to %04x", e.Addr)
+	}
+	return fmt.Sprintf("unmapped read from %04x", e.Addr)
+}
+
+// Register connects d to the Bus. An error is returned if the range claimed
+// by d is invalid, or overlaps internal RAM or another Device.
+func (b *Bus) Register(d Device) error {
+	start, end := d.Range()
+	switch {
+	case start > end:
+		return fmt.Errorf("invalid range %04x-%04x", start, end)
+	case start < 0x2000:
+		return fmt.Errorf("range %04x-%04x overlaps internal RAM", start, end)
+	case start < 0x4000 && end > 0x2007:
+		// addresses in 0x2008-0x3fff are resolved to 0x2000-0x2007
+		// before the lookup, so a Device could never be reached there
+		return fmt.Errorf("range %04x-%04x overlaps PPU register mirrors", start, end)
+	}
+	for _, other := range b.devices {
+		s, e := other.Range()
+		if start <= e && s <= end {
+			return fmt.Errorf(
+				"range %04x-%04x overlaps existing device at %04x-%04x",
+				start, end, s, e,
+			)
+		}
+	}
+	b.devices = append(b.devices, d)
+	return nil
+}
+
+// mirror resolves an addr to its canonical location. Internal RAM (2 kB) is
+// mirrored 4 times in 0x0000-0x1fff, and the 8 PPU registers are mirrored
+// every 8 bytes in 0x2000-0x3fff.
+func mirror(addr uint16) uint16 {
+	switch {
+	case addr < 0x2000:
+		return addr & 0x07ff
+	case addr < 0x4000:
+		return 0x2000 | addr&0x0007
+	default:
+		return addr
+	}
+}
+
+// device returns the Device that claimed addr, which may be nil. Addresses in
+// internal RAM have no Device.
+func (b *Bus) device(addr uint16) Device {
+	for _, d := range b.devices {
+		if start, end := d.Range(); start <= addr && addr <= end {
+			return d
+		}
+	}
+	return nil
+}
+
+// Mapped reports whether addr is in internal RAM, or claimed by a Device.
+func (b *Bus) Mapped(addr uint16) bool {
+	addr = mirror(addr)
+	return addr < 0x2000 || b.device(addr) != nil
+}
+
+func (b *Bus) unmapped(addr uint16, write bool) {
+	if b.Err == nil {
+		b.Err = &UnmappedError{Addr: addr, Write: write}
+	}
+}
+
+// Write passes data to the internal RAM or Device at addr.
 func (b *Bus) Write(
 	addr uint16, // addresses are 2 bytes wide
 	data byte,
 ) {
-	b.FakeRam[addr] = data
+	b.data = data
+	addr = mirror(addr)
+	if addr < 0x2000 {
+		b.Ram[addr] = data
+		return
+	}
+	d := b.device(addr)
+	if d == nil {
+		b.unmapped(addr, true)
+		return
+	}
+	d.Write(addr, data)
 }
 
-func (b *Bus) Read(addr uint16, readonly bool) byte { return b.FakeRam[addr] }
+// Read returns the byte at addr. If readonly is true, the read is a 'peek',
+// which has no side effects whatsoever, neither in the Device nor in the Bus
+// itself. Peeks are meant for debuggers and other tooling that inspects
+// memory; emulated components must always read with readonly set to false.
+func (b *Bus) Read(addr uint16, readonly bool) byte {
+	addr = mirror(addr)
+	if addr < 0x2000 {
+		if !readonly {
+			b.data = b.Ram[addr]
+		}
+		return b.Ram[addr]
+	}
+	d := b.device(addr)
+	if d == nil {
+		if !readonly {
+			b.unmapped(addr, false)
+		}
+		return b.data
+	}
+	data := d.Read(addr, readonly)
+	if !readonly {
+		b.data = data
+	}
+	return data
+}
+
+// OpenBus returns the last byte that passed through the Bus. Devices that do
+// not drive all data lines (e.g. unused bits of a register) read as this
+// value.
+func (b *Bus) OpenBus() byte {
+	return b.data
+}
 
-// func newBus() Bus {
-// 	return Bus{}
-// }
+// Memory is a plain block of bytes that can be connected to any region of a
+// Bus. It is mostly useful for placing programs in cartridge space without a
+// real cartridge.
+type Memory struct {
+	Start uint16 // the absolute address of Data[0]
+	Data  []byte
+}
+
+// NewMemory allocates a Memory spanning the inclusive range [start:end].
+func NewMemory(start uint16, end uint16) *Memory {
+	return &Memory{Start: start, Data: make([]byte, int(end-start)+1)}
+}
+
+func (m *Memory) Range() (uint16, uint16) {
+	return m.Start, m.Start + uint16(len(m.Data)-1)
+}
+
+func (m *Memory) Read(addr uint16, readonly bool) byte {
+	i := int(addr - m.Start)
+	if i >= len(m.Data) {
+		return 0
+	}
+	return m.Data[i]
+}
+
+func (m *Memory) Write(addr uint16, data byte) {
+	i := int(addr - m.Start)
+	if i >= len(m.Data) {
+		return
+	}
+	m.Data[i] = data
+}
@@ -0,0 +1,111 @@
+package mem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// registers claims an arbitrary range, and records the (mirrored) addresses
+// it is accessed with.
+type registers struct {
+	start  uint16
+	end    uint16
+	reads  []uint16
+	writes []uint16
+}
+
+func (r *registers) Range() (uint16, uint16) { return r.start, r.end }
+
+func (r *registers) Read(addr uint16, readonly bool) byte {
+	r.reads = append(r.reads, addr)
+	return byte(addr)
+}
+
+func (r *registers) Write(addr uint16, data byte) {
+	r.writes = append(r.writes, addr)
+}
+
+func TestBusMirroring(t *testing.T) {
+	b := Bus{}
+
+	// internal RAM is mirrored 4x
+	b.Write(0x0001, 0xaa)
+	assert.Equal(t, b.Read(0x0801, true), byte(0xaa))
+	assert.Equal(t, b.Read(0x1001, true), byte(0xaa))
+	assert.Equal(t, b.Read(0x1801, true), byte(0xaa))
+	b.Write(0x1fff, 0xbb)
+	assert.Equal(t, b.Ram[0x07ff], byte(0xbb))
+
+	// PPU registers are mirrored every 8 bytes
+	ppu := &registers{start: 0x2000, end: 0x2007}
+	assert.NoError(t, b.Register(ppu))
+	b.Write(0x2000, 0)
+	b.Write(0x2008, 0)
+	b.Write(0x3fff, 0)
+	assert.Equal(t, ppu.writes, []uint16{0x2000, 0x2000, 0x2007})
+	assert.Equal(t, b.Read(0x3456, true), byte(0x06))
+}
+
+func TestBusRouting(t *testing.T) {
+	apu := &registers{start: 0x4000, end: 0x401f}
+	cart := &registers{start: 0x4020, end: 0xffff}
+	b := Bus{}
+	assert.NoError(t, b.Register(apu))
+	assert.NoError(t, b.Register(cart))
+
+	b.Read(0x4000, false)
+	b.Read(0x401f, false)
+	b.Read(0x4020, false)
+	b.Read(0xffff, false)
+	assert.Equal(t, apu.reads, []uint16{0x4000, 0x401f})
+	assert.Equal(t, cart.reads, []uint16{0x4020, 0xffff})
+	assert.NoError(t, b.Err)
+}
+
+func TestBusRegister(t *testing.T) {
+	b := Bus{}
+	assert.NoError(t, b.Register(&registers{start: 0x4000, end: 0x4017}))
+	assert.NoError(t, b.Register(NewMemory(0x6000, 0x7fff)))
+
+	for _, r := range []*registers{
+		{start: 0x4017, end: 0x401f}, // overlaps 0x4000-0x4017
+		{start: 0x5000, end: 0x6000}, // overlaps 0x6000-0x7fff
+		{start: 0x4000, end: 0xffff}, // overlaps both
+		{start: 0x1800, end: 0x2007}, // overlaps internal RAM
+		{start: 0x2000, end: 0x3fff}, // overlaps PPU mirrors
+		{start: 0x2100, end: 0x21ff}, // only PPU mirrors
+		{start: 0x9000, end: 0x8000}, // backwards
+	} {
+		assert.Error(t, b.Register(r), "%04x-%04x", r.start, r.end)
+	}
+
+	assert.NoError(t, b.Register(&registers{start: 0x4018, end: 0x401f}))
+	assert.NoError(t, b.Register(&registers{start: 0x2000, end: 0x2007}))
+}
+
+func TestBusUnmapped(t *testing.T) {
+	b := Bus{}
+	assert.NoError(t, b.Register(NewMemory(0x6000, 0x7fff)))
+	assert.True(t, b.Mapped(0x1800))
+	assert.True(t, b.Mapped(0x7fff))
+	assert.False(t, b.Mapped(0x2002))
+	assert.False(t, b.Mapped(0x8000))
+
+	// peeking never records an error
+	b.Read(0x8000, true)
+	assert.NoError(t, b.Err)
+
+	// unclaimed addresses return the last byte on the bus
+	b.Write(0x0000, 0x12)
+	assert.Equal(t, b.Read(0x2002, false), byte(0x12))
+	assert.Equal(t, b.Err, &UnmappedError{Addr: 0x2002})
+
+	// only the first error is kept
+	b.Write(0x4020, 0)
+	assert.EqualError(t, b.Err, "unmapped read from 2002")
+
+	b.Err = nil
+	b.Write(0x4020, 0)
+	assert.EqualError(t, b.Err, "unmapped write to 4020")
+}
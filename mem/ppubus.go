@@ -0,0 +1,109 @@
+package mem
+
+import "gone/cartridge"
+
+// https://www.nesdev.org/wiki/PPU_memory_map
+// https://www.nesdev.org/wiki/Mirroring#Nametable_Mirroring
+// https://www.nesdev.org/wiki/PPU_palettes#Memory_Map
+
+// Chr is the part of a cartridge (i.e. its mapper) that is connected to the
+// PPU Bus.
+type Chr interface {
+	ReadChr(addr uint16) byte
+	WriteChr(addr uint16, data byte)
+	Mirroring() cartridge.Mirroring
+}
+
+// A PpuBus is the second Bus of the NES, which is only accessible to the PPU
+// (the Cpu can only access it indirectly, via PPU registers). It spans 16 kB
+// (0x0000-0x3fff):
+//
+//	0000-1fff  pattern tables (CHR), provided by the cartridge
+//	2000-2fff  4 nametables of 1 kB, provided by VRAM (mirrored)
+//	3000-3eff  mirror of 2000-2eff
+//	3f00-3f1f  palette RAM
+//	3f20-3fff  mirrors of 3f00-3f1f
+//
+// Addresses above 0x3fff wrap around.
+type PpuBus struct {
+	Cart Chr
+
+	// The console only has 2 kB of VRAM, i.e. 2 nametables; the other 2
+	// are mirrors, as determined by the cartridge. Cartridges with
+	// FourScreen mirroring provide the remaining 2 kB themselves, which
+	// we simply place after the first 2 kB.
+	Vram [4 * 1024]byte
+
+	// 32 entries, each indexing into the 64 colours of the system
+	// palette. 0x3f00 is the universal background colour.
+	Palette [32]byte
+}
+
+// nametable resolves an addr in 0x2000-0x3eff to an index into Vram,
+// according to the current mirroring.
+func (b *PpuBus) nametable(addr uint16) uint16 {
+	addr = (addr - 0x2000) & 0x0fff
+	table := addr / 0x0400
+	offset := addr % 0x0400
+
+	mirroring := cartridge.Horizontal
+	if b.Cart != nil {
+		mirroring = b.Cart.Mirroring()
+	}
+
+	// physical table (1 kB of Vram) for each logical table
+	var physical [4]uint16
+	switch mirroring {
+	case cartridge.Horizontal:
+		physical = [4]uint16{0, 0, 1, 1}
+	case cartridge.Vertical:
+		physical = [4]uint16{0, 1, 0, 1}
+	case cartridge.SingleScreenLower:
+		physical = [4]uint16{0, 0, 0, 0}
+	case cartridge.SingleScreenUpper:
+		physical = [4]uint16{1, 1, 1, 1}
+	case cartridge.FourScreen:
+		physical = [4]uint16{0, 1, 2, 3}
+	}
+	return physical[table]*0x0400 + offset
+}
+
+// palette resolves an addr in 0x3f00-0x3fff to an index into Palette. The
+// 'transparent' entries of the sprite palettes (0x3f10, 0x3f14, 0x3f18,
+// 0x3f1c) are mirrors of the corresponding background entries.
+func palette(addr uint16) uint16 {
+	i := addr & 0x001f
+	if i >= 0x10 && i%4 == 0 {
+		i -= 0x10
+	}
+	return i
+}
+
+func (b *PpuBus) Read(addr uint16) byte {
+	addr &= 0x3fff
+	switch {
+	case addr < 0x2000:
+		if b.Cart == nil {
+			return 0
+		}
+		return b.Cart.ReadChr(addr)
+	case addr < 0x3f00:
+		return b.Vram[b.nametable(addr)]
+	default:
+		return b.Palette[palette(addr)]
+	}
+}
+
+func (b *PpuBus) Write(addr uint16, data byte) {
+	addr &= 0x3fff
+	switch {
+	case addr < 0x2000:
+		if b.Cart != nil {
+			b.Cart.WriteChr(addr, data)
+		}
+	case addr < 0x3f00:
+		b.Vram[b.nametable(addr)] = data
+	default:
+		b.Palette[palette(addr)] = data & 0x3f // 6-bit
+	}
+}
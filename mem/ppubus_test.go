@@ -0,0 +1,93 @@
+package mem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gone/cartridge"
+)
+
+type chr struct {
+	data      [8 * 1024]byte
+	mirroring cartridge.Mirroring
+}
+
+func (c *chr) ReadChr(addr uint16) byte        { return c.data[addr] }
+func (c *chr) WriteChr(addr uint16, data byte) { c.data[addr] = data }
+func (c *chr) Mirroring() cartridge.Mirroring  { return c.mirroring }
+
+func TestPpuBusPatterns(t *testing.T) {
+	c := &chr{}
+	b := PpuBus{Cart: c}
+	b.Write(0x1234, 0x56)
+	assert.Equal(t, c.data[0x1234], byte(0x56))
+	assert.Equal(t, b.Read(0x5234), byte(0x56)) // wraps around
+}
+
+func TestPpuBusMirroring(t *testing.T) {
+	c := &chr{}
+	b := PpuBus{Cart: c}
+
+	for _, m := range []struct {
+		mirroring cartridge.Mirroring
+		same      [][2]uint16 // pairs of addresses that share Vram
+		different [][2]uint16
+	}{
+		{
+			mirroring: cartridge.Horizontal,
+			same:      [][2]uint16{{0x2000, 0x2400}, {0x2800, 0x2c00}},
+			different: [][2]uint16{{0x2000, 0x2800}},
+		},
+		{
+			mirroring: cartridge.Vertical,
+			same:      [][2]uint16{{0x2000, 0x2800}, {0x2400, 0x2c00}},
+			different: [][2]uint16{{0x2000, 0x2400}},
+		},
+		{
+			mirroring: cartridge.SingleScreenLower,
+			same:      [][2]uint16{{0x2000, 0x2400}, {0x2000, 0x2800}, {0x2000, 0x2c00}},
+		},
+		{
+			mirroring: cartridge.FourScreen,
+			different: [][2]uint16{{0x2000, 0x2400}, {0x2000, 0x2800}, {0x2400, 0x2c00}},
+		},
+	} {
+		c.mirroring = m.mirroring
+		for i, pair := range m.same {
+			b.Write(pair[0]+0x10, byte(i+1))
+			assert.Equal(t, b.Read(pair[1]+0x10), byte(i+1), "%s: %04x", m.mirroring, pair)
+		}
+		for i, pair := range m.different {
+			b.Write(pair[0]+0x20, byte(i+1))
+			b.Write(pair[1]+0x20, 0)
+			assert.Equal(t, b.Read(pair[0]+0x20), byte(i+1), "%s: %04x", m.mirroring, pair)
+		}
+	}
+
+	// 0x3000-0x3eff mirrors 0x2000-0x2eff
+	c.mirroring = cartridge.Vertical
+	b.Write(0x2123, 0x77)
+	assert.Equal(t, b.Read(0x3123), byte(0x77))
+}
+
+func TestPpuBusPalette(t *testing.T) {
+	b := PpuBus{}
+
+	b.Write(0x3f00, 0x0f)
+	assert.Equal(t, b.Read(0x3f10), byte(0x0f))
+	b.Write(0x3f14, 0x21)
+	assert.Equal(t, b.Read(0x3f04), byte(0x21))
+
+	// other sprite entries are not mirrored
+	b.Write(0x3f11, 0x30)
+	assert.Equal(t, b.Read(0x3f01), byte(0))
+
+	// 0x3f20-0x3fff mirrors 0x3f00-0x3f1f
+	assert.Equal(t, b.Read(0x3f31), byte(0x30))
+	assert.Equal(t, b.Read(0x3fe0), byte(0x0f))
+
+	// only 6 bits are stored
+	b.Write(0x3f02, 0xff)
+	assert.Equal(t, b.Read(0x3f02), byte(0x3f))
+}
@@ -0,0 +1,55 @@
+package nes
+
+import "gone/cartridge"
+
+// https://www.nesdev.org/wiki/Cycle_reference_chart
+// https://www.nesdev.org/wiki/Clock_rate
+
+// A clock is the master clock (crystal) of the console, from which the Cpu and
+// Ppu clocks are derived by dividing it. Since the dividers are not always
+// multiples of each other (e.g. on PAL, there are 3.2 dots per Cpu cycle),
+// each component is scheduled by the master cycle at which it next runs.
+type clock struct {
+	rate       float64 // master cycles per second
+	frameRate  float64 // frames per second
+	cpuDivider uint64  // master cycles per Cpu cycle
+	ppuDivider uint64  // master cycles per Ppu dot
+
+	master  uint64 // the master cycle of the next Cpu cycle
+	nextDot uint64 // the master cycle of the next Ppu dot
+}
+
+var (
+	// 341 * 262 dots per frame, minus half a dot (skipped on odd frames)
+	ntscClock = clock{rate: 236.25e6 / 11, frameRate: 60.0988, cpuDivider: 12, ppuDivider: 4}
+	// 341 * 312 dots per frame
+	palClock = clock{rate: 26.6017125e6, frameRate: 50.007, cpuDivider: 16, ppuDivider: 5}
+	// same as PAL, but with 3 dots per Cpu cycle
+	dendyClock = clock{rate: 26.6017125e6, frameRate: 50.007, cpuDivider: 15, ppuDivider: 5}
+)
+
+func regionClock(r cartridge.Region) clock {
+	switch r {
+	case cartridge.PAL:
+		return palClock
+	case cartridge.Dendy:
+		return dendyClock
+	default:
+		return ntscClock
+	}
+}
+
+// cpuRate returns the number of Cpu cycles per second.
+func (c *clock) cpuRate() float64 { return c.rate / float64(c.cpuDivider) }
+
+// step advances the clock by a single Cpu cycle, and returns the number of Ppu
+// dots that elapse during that cycle.
+func (c *clock) step() int {
+	c.master += c.cpuDivider
+	dots := 0
+	for c.nextDot < c.master {
+		c.nextDot += c.ppuDivider
+		dots++
+	}
+	return dots
+}
@@ -0,0 +1,210 @@
+// Package nes ties the individual components (Cpu, PPU, APU, cartridge and
+// controllers) together into a complete system.
+
+package nes
+
+import (
+	"gone/apu"
+	"gone/cartridge"
+	"gone/controller"
+	"gone/cpu"
+	"gone/mapper"
+	"gone/mem"
+	"gone/ppu"
+)
+
+// A Console owns all the components of an NES, connected to each other as on
+// real hardware. Applications should only need to load a cartridge, set the
+// state of the controllers, and call one of the Step/Run methods.
+//
+//	Cpu ---- Bus ---- RAM
+//	          |------ Ppu ---- PpuBus ---- Mapper (CHR), VRAM, palettes
+//	          |------ Apu ---- Dma, Ports
+//	          +------ Mapper (PRG)
+type Console struct {
+	Cpu    *cpu.Cpu
+	Ppu    *ppu.Ppu
+	Apu    *apu.Apu
+	Bus    *mem.Bus
+	Mapper mapper.Mapper
+	Cart   *cartridge.Cartridge
+
+	// Ports holds the devices plugged into the controller ports. By
+	// default, these are Controllers.
+	Ports       *controller.Ports
+	Controllers [2]*controller.Controller
+
+	// Audio, if not nil, receives the output of the Apu every cycle.
+	Audio *apu.Resampler
+
+	region cartridge.Region
+	clock  clock
+}
+
+// New connects the cartridge to a new Console, and powers it on.
+func New(cart *cartridge.Cartridge) (*Console, error) {
+	m, err := mapper.New(cart)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Console{
+		Cpu:         &cpu.Cpu{Bus: &mem.Bus{}},
+		Ppu:         &ppu.Ppu{Bus: &mem.PpuBus{Cart: m}},
+		Apu:         apu.New(),
+		Mapper:      m,
+		Cart:        cart,
+		Controllers: [2]*controller.Controller{{}, {}},
+	}
+	c.Bus = c.Cpu.Bus
+	c.Ports = &controller.Ports{Port1: c.Controllers[0], Port2: c.Controllers[1]}
+
+	c.Apu.Dma = &cpu.Dma{Cpu: c.Cpu}
+	c.Apu.Input = c.Ports
+	c.Apu.Irq = func(active bool) { c.Cpu.SetIRQ(cpu.IrqApu, active) }
+	c.Apu.Fetch = c.Cpu.Read
+	c.Apu.Stall = c.Cpu.Stall
+	c.Apu.OpenBus = c.Bus.OpenBus
+
+	c.Ppu.Nmi = c.Cpu.SetNMI
+	if sc, ok := m.(mapper.ScanlineCounter); ok {
+		c.Ppu.OnScanline = sc.Scanline
+	}
+	if i, ok := m.(mapper.Interrupter); ok {
+		i.SetIrq(func(active bool) { c.Cpu.SetIRQ(cpu.IrqMapper, active) })
+	}
+
+	for _, d := range []mem.Device{c.Ppu, c.Apu, m} {
+		if err := c.Bus.Register(d); err != nil {
+			return nil, err
+		}
+	}
+
+	c.SetRegion(cart.Region)
+	c.Reset()
+	return c, nil
+}
+
+// Open loads the ROM file at path into a new Console.
+func Open(path string) (*Console, error) {
+	cart, err := cartridge.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(cart)
+}
+
+// SetRegion changes the timing of the Console, overriding the Region declared
+// by the cartridge. Multi-region cartridges run as NTSC.
+func (c *Console) SetRegion(r cartridge.Region) {
+	if r == cartridge.Multi {
+		r = cartridge.NTSC
+	}
+	c.region = r
+	c.clock = regionClock(r)
+	c.Ppu.Region = r
+	c.Apu.Region = r
+}
+
+// Region returns the Region that the Console is running as.
+func (c *Console) Region() cartridge.Region { return c.region }
+
+// Reset presses the reset button. Internal RAM, VRAM and cartridge RAM are
+// retained; the Cpu resumes execution at the address found at 0xfffc.
+func (c *Console) Reset() {
+	c.Ppu.Reset()
+	c.Apu.Reset()
+	c.Cpu.Reset()
+}
+
+// SetCycleAccurate switches the Cpu in or out of its cycle-accurate mode. In
+// this mode, the Ppu and Apu are clocked between the individual bus accesses
+// of each instruction (instead of after the whole instruction), and dummy
+// reads and writes are performed like on hardware. This is slower, but some
+// games depend on it, e.g. when reading Ppu registers mid-instruction.
+func (c *Console) SetCycleAccurate(on bool) {
+	if on {
+		c.Cpu.OnCycle = c.cycle
+	} else {
+		c.Cpu.OnCycle = nil
+	}
+}
+
+// Step advances the Console by a single Cpu cycle. The Apu is clocked along
+// with the Cpu, and the Ppu for every dot that elapses during the cycle (3 on
+// NTSC). The interrupt outputs of either are polled by the Cpu during each
+// instruction, and serviced after it.
+//
+// In cycle-accurate mode, Step advances by a whole instruction instead,
+// clocking the Apu and Ppu on every cycle of it.
+//
+// Nothing here depends on real time; the caller decides how fast to run.
+func (c *Console) Step() error {
+	if c.Cpu.OnCycle != nil {
+		return c.Cpu.Clock()
+	}
+	err := c.Cpu.Clock()
+	c.cycle()
+	return err
+}
+
+// cycle clocks everything but the Cpu for one Cpu cycle.
+func (c *Console) cycle() {
+	c.Apu.Step()
+	if c.Audio != nil {
+		c.Audio.Add(c.Apu.Output())
+	}
+	for range c.clock.step() {
+		c.Ppu.Step()
+	}
+}
+
+// CpuRate returns the number of Cpu cycles per second.
+func (c *Console) CpuRate() float64 { return c.clock.cpuRate() }
+
+// FrameRate returns the number of frames per second.
+func (c *Console) FrameRate() float64 { return c.clock.frameRate }
+
+// StepInstruction completes the current instruction (if any), then executes
+// the next one in full. A pending interrupt is serviced instead of the next
+// instruction.
+func (c *Console) StepInstruction() error {
+	for c.Cpu.Cycles > 0 || c.Cpu.Stalled() {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	if err := c.Step(); err != nil {
+		return err
+	}
+	for c.Cpu.Cycles > 0 {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepFrame runs until the Ppu has completed the current frame, i.e. until the
+// start of the next vblank.
+func (c *Console) StepFrame() error {
+	frames := c.Ppu.Frames
+	for c.Ppu.Frames == frames {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunFor runs the given number of Cpu cycles. In cycle-accurate mode, the
+// current instruction is completed, so slightly more cycles may elapse.
+func (c *Console) RunFor(cycles int) error {
+	end := c.Cpu.TotalCycles + uint64(cycles)
+	for c.Cpu.TotalCycles < end {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package nes
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gone/cartridge"
+)
+
+// newCart returns an NROM cartridge with 16 kB of PRG-ROM (mirrored at
+// 0x8000 and 0xc000), containing the given programs (hex strings), keyed by
+// address.
+func newCart(programs map[uint16]string) *cartridge.Cartridge {
+	prg := make([]byte, 16*1024)
+	for addr, program := range programs {
+		for i, s := range strings.Fields(program) {
+			b, err := strconv.ParseUint(s, 16, 8)
+			if err != nil {
+				panic(err)
+			}
+			prg[int(addr)%len(prg)+i] = byte(b)
+		}
+	}
+	return &cartridge.Cartridge{
+		Header: cartridge.Header{PrgRomSize: len(prg)},
+		PrgRom: prg,
+	}
+}
+
+func TestConsole(t *testing.T) {
+	c, err := New(newCart(map[uint16]string{
+		0x8000: "A9 80 8D 00 20 D0 FE", // LDA #$80; STA $2000 (NMI on); loop
+		0x9000: "A9 01 85 10 D0 FE",    // LDA #$01; STA $10; loop
+		0xfffa: "00 90 00 80",          // NMI, reset vectors
+	}))
+	assert.NoError(t, err)
+
+	// the reset sequence, then LDA
+	assert.NoError(t, c.StepInstruction())
+	assert.Equal(t, c.Cpu.ProgramCounter, uint16(0x8002))
+	assert.Equal(t, c.Cpu.Accumulator, byte(0x80))
+	assert.Equal(t, c.Cpu.TotalCycles, uint64(8+2))
+	assert.Equal(t, c.Ppu.Dot, (8+2)*3)
+
+	assert.NoError(t, c.StepInstruction())
+	assert.Equal(t, c.Cpu.ProgramCounter, uint16(0x8005))
+
+	assert.NoError(t, c.StepFrame())
+	assert.Equal(t, c.Ppu.Frames, uint64(1))
+	assert.Equal(t, c.Cpu.Peek(0x10), byte(0))
+
+	// the NMI is serviced at the next instruction
+	assert.NoError(t, c.RunFor(20))
+	assert.Equal(t, c.Cpu.ProgramCounter, uint16(0x9004))
+	assert.Equal(t, c.Cpu.Peek(0x10), byte(1))
+
+	// reset retains RAM
+	c.Reset()
+	assert.NoError(t, c.StepInstruction())
+	assert.Equal(t, c.Cpu.ProgramCounter, uint16(0x8002))
+	assert.Equal(t, c.Cpu.Peek(0x10), byte(1))
+}
+
+func TestCycleAccurate(t *testing.T) {
+	program := map[uint16]string{
+		0x8000: "A9 20 8D 06 20 A9 00 8D 06 20" + // PPUADDR = $2000
+			" A9 55 A2 07 9D 00 20" + // LDA #$55; LDX #$07; STA $2000,X
+			" D0 FE", // loop
+		0xfffc: "00 80",
+	}
+	for _, accurate := range []bool{false, true} {
+		c, err := New(newCart(program))
+		assert.NoError(t, err)
+		c.SetCycleAccurate(accurate)
+		assert.NoError(t, c.RunFor(100))
+		assert.Equal(t, c.Cpu.ProgramCounter, uint16(0x8011))
+
+		// the indexed store first reads the uncorrected address, which
+		// happens to be PPUDATA, so the Ppu address is incremented
+		// before the write
+		if accurate {
+			assert.Equal(t, c.Ppu.Bus.Read(0x2000), byte(0))
+			assert.Equal(t, c.Ppu.Bus.Read(0x2001), byte(0x55))
+		} else {
+			assert.Equal(t, c.Ppu.Bus.Read(0x2000), byte(0x55))
+		}
+		assert.Equal(t, c.Ppu.Dot, int(c.Cpu.TotalCycles)*3)
+	}
+}
+
+func TestConsoleUnsupported(t *testing.T) {
+	cart := newCart(nil)
+	cart.Mapper = 99
+	_, err := New(cart)
+	assert.EqualError(t, err, "unsupported mapper: 99")
+}
+
+func TestClock(t *testing.T) {
+	c := regionClock(cartridge.Multi)
+	for range 100 {
+		assert.Equal(t, c.step(), 3)
+	}
+	assert.InDelta(t, c.cpuRate(), 1789773, 1)
+
+	// 16 dots for every 5 Cpu cycles
+	c = regionClock(cartridge.PAL)
+	var dots []int
+	for range 5 {
+		dots = append(dots, c.step())
+	}
+	assert.Equal(t, dots, []int{4, 3, 3, 3, 3})
+	assert.InDelta(t, c.cpuRate(), 1662607, 1)
+}
+
+func TestRegion(t *testing.T) {
+	cart := newCart(map[uint16]string{0x8000: "D0 FE", 0xfffc: "00 80"})
+	cart.Region = cartridge.Multi
+	c, err := New(cart)
+	assert.NoError(t, err)
+	assert.Equal(t, c.Region(), cartridge.NTSC)
+
+	for _, tc := range []struct {
+		region cartridge.Region
+		cycles uint64 // per frame
+	}{
+		{cartridge.NTSC, 29780}, // or 29781
+		{cartridge.PAL, 33247},  // or 33248
+		{cartridge.Dendy, 35464},
+	} {
+		c.SetRegion(tc.region)
+		assert.NoError(t, c.StepFrame())
+		start := c.Cpu.TotalCycles
+		assert.NoError(t, c.StepFrame())
+		assert.InDelta(t, c.Cpu.TotalCycles-start, tc.cycles, 1, tc.region)
+		assert.InDelta(t, float64(tc.cycles)*c.FrameRate(), c.CpuRate(), c.CpuRate()/1000, tc.region)
+	}
+}
@@ -0,0 +1,100 @@
+package nes
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Speeds commonly passed to Runner.SetSpeed. Any other positive value is
+// allowed, e.g. 2 to fast-forward, or 0.5 for slow motion.
+const (
+	Uncapped = 0 // run as fast as possible
+	Normal   = 1
+)
+
+// maxLag is how far a Runner may fall behind real time before it gives up on
+// catching up (e.g. after the process was suspended).
+const maxLag = 250 * time.Millisecond
+
+// A Runner runs a Console in real time. Each frame is emulated at full speed,
+// after which the Runner sleeps until the frame is due, at the frame rate of
+// the Console (~60.1 Hz on NTSC, ~50 Hz on PAL).
+//
+// Pacing is based on the time elapsed since the Runner started (rather than
+// sleeping a fixed amount per frame), so that errors in sleep duration do not
+// accumulate.
+type Runner struct {
+	Console *Console
+
+	mu    sync.Mutex
+	speed float64
+
+	// for testing
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewRunner returns a Runner for c at Normal speed.
+func NewRunner(c *Console) *Runner {
+	return &Runner{Console: c, speed: Normal, now: time.Now, sleep: time.Sleep}
+}
+
+// SetSpeed changes the speed of emulation, relative to real time (1 = Normal).
+// It may be called while the Runner is running, e.g. from the frontend.
+func (r *Runner) SetSpeed(speed float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.speed = max(speed, 0)
+}
+
+// Speed returns the current speed of emulation.
+func (r *Runner) Speed() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.speed
+}
+
+// Run runs the Console until ctx is done, or an error occurs. After each
+// frame, onFrame (if not nil) is called, typically to present the frame and
+// update the controllers; if it returns an error, Run stops and returns it.
+func (r *Runner) Run(ctx context.Context, onFrame func(*Console) error) error {
+	var (
+		start  time.Time
+		frames float64 // since start
+		speed  float64 = -1
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := r.Console.StepFrame(); err != nil {
+			return err
+		}
+		if onFrame != nil {
+			if err := onFrame(r.Console); err != nil {
+				return err
+			}
+		}
+
+		if s := r.Speed(); s != speed {
+			// the schedule is restarted whenever the speed changes
+			speed = s
+			start = r.now()
+			frames = 0
+		}
+		if speed == Uncapped {
+			continue
+		}
+
+		frames++
+		due := start.Add(time.Duration(frames / (r.Console.FrameRate() * speed) * float64(time.Second)))
+		switch wait := due.Sub(r.now()); {
+		case wait > 0:
+			r.sleep(wait)
+		case wait < -maxLag:
+			start = r.now()
+			frames = 0
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package nes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errStop = errors.New("stop")
+
+// newRunner returns a Runner with a fake clock, which only advances when the
+// Runner sleeps (or when advanced manually).
+func newRunner(t *testing.T) (*Runner, *time.Time) {
+	c, err := New(newCart(map[uint16]string{
+		0x8000: "D0 FE",
+		0xfffc: "00 80",
+	}))
+	assert.NoError(t, err)
+
+	now := time.Unix(0, 0)
+	r := NewRunner(c)
+	r.now = func() time.Time { return now }
+	r.sleep = func(d time.Duration) { now = now.Add(d) }
+	return r, &now
+}
+
+// stopAfter returns an onFrame func that stops the Runner after n frames.
+func stopAfter(n int, each func(frame int)) func(*Console) error {
+	frame := 0
+	return func(*Console) error {
+		frame++
+		if each != nil {
+			each(frame)
+		}
+		if frame == n {
+			return errStop
+		}
+		return nil
+	}
+}
+
+func TestRunner(t *testing.T) {
+	for _, tc := range []struct {
+		speed    float64
+		expected time.Duration
+	}{
+		{Normal, 2 * time.Second},
+		{2, time.Second},
+		{0.5, 4 * time.Second},
+		{Uncapped, 0},
+	} {
+		r, now := newRunner(t)
+		r.SetSpeed(tc.speed)
+		// the schedule starts after the 1st frame
+		err := r.Run(context.Background(), stopAfter(1+120, nil))
+		assert.ErrorIs(t, err, errStop)
+		assert.InDelta(t, now.Sub(time.Unix(0, 0)), tc.expected, float64(20*time.Millisecond), tc.speed)
+		assert.Equal(t, r.Console.Ppu.Frames, uint64(1+120))
+	}
+}
+
+func TestRunnerLag(t *testing.T) {
+	r, now := newRunner(t)
+	err := r.Run(context.Background(), stopAfter(1+120, func(frame int) {
+		if frame == 60 {
+			// e.g. the process was suspended
+			*now = now.Add(time.Hour)
+		}
+	}))
+	assert.ErrorIs(t, err, errStop)
+	// no attempt is made to catch up
+	assert.InDelta(t, now.Sub(time.Unix(0, 0)), time.Hour+2*time.Second, float64(20*time.Millisecond))
+}
+
+func TestRunnerCancel(t *testing.T) {
+	r, _ := newRunner(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	err := r.Run(ctx, stopAfter(10, func(frame int) {
+		if frame == 5 {
+			cancel()
+		}
+	}))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, r.Console.Ppu.Frames, uint64(5))
+}
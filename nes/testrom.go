@@ -0,0 +1,96 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+)
+
+// https://github.com/christopherpow/nes-test-roms/blob/master/instr_test-v5/readme.txt
+//
+// most test ROMs (by blargg, and others following his conventions) report
+// their progress in PRG-RAM:
+//
+//	$6000      status: 0x80 while running, 0x81 if a reset is needed, else
+//	           the result (0 = passed)
+//	$6001-6003 signature (de b0 61), so that garbage is not mistaken for a
+//	           status
+//	$6004-     text output, null-terminated
+
+const (
+	testStatus    = 0x6000
+	testSignature = 0x6001
+	testText      = 0x6004
+
+	testRunning      = 0x80
+	testResetPending = 0x81
+
+	// "wait at least 100 msec" before pressing reset
+	testResetFrames = 10
+)
+
+// ErrTestTimeout is returned by RunTest if the test ROM has not reported its
+// result in time.
+var ErrTestTimeout = errors.New("test ROM did not finish in time")
+
+// A TestResult is what a test ROM reported.
+type TestResult struct {
+	Status byte   // 0 if the test passed, otherwise an error code
+	Text   string // usually a description of the tests run, and of any failures
+}
+
+// Passed reports whether the test ROM passed.
+func (r TestResult) Passed() bool { return r.Status == 0 }
+
+// RunTest runs a test ROM for at most maxFrames frames, until it reports a
+// result. Resets are performed when the ROM asks for them. If the time runs
+// out, the result so far is returned, along with ErrTestTimeout.
+func (c *Console) RunTest(maxFrames int) (TestResult, error) {
+	resetAt := -1
+	for frame := range maxFrames {
+		if err := c.StepFrame(); err != nil {
+			return c.testResult(), err
+		}
+		if !c.testStarted() {
+			continue
+		}
+		switch status := c.Cpu.Peek(testStatus); {
+		case status == testRunning:
+		case status == testResetPending:
+			if resetAt < 0 {
+				resetAt = frame + testResetFrames
+			}
+			if frame >= resetAt {
+				resetAt = -1
+				c.Reset()
+			}
+		default:
+			return c.testResult(), nil
+		}
+	}
+	return c.testResult(), ErrTestTimeout
+}
+
+func (c *Console) testStarted() bool {
+	for i, b := range []byte{0xde, 0xb0, 0x61} {
+		if c.Cpu.Peek(testSignature+uint16(i)) != b {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Console) testResult() TestResult {
+	var text bytes.Buffer
+	for addr := uint16(testText); addr < 0x8000; addr++ {
+		b := c.Cpu.Peek(addr)
+		if b == 0 {
+			break
+		}
+		text.WriteByte(b)
+	}
+	status := c.Cpu.Peek(testStatus)
+	if !c.testStarted() {
+		status = testRunning // i.e. nothing reported yet
+	}
+	return TestResult{Status: status, Text: text.String()}
+}
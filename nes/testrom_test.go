@@ -0,0 +1,65 @@
+package nes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testProgram writes the signature, the text "ok", and the given status.
+func testProgram(status string) string {
+	return `
+		A9 DE 8D 01 60 A9 B0 8D 02 60 A9 61 8D 03 60
+		A9 6F 8D 04 60 A9 6B 8D 05 60 A9 00 8D 06 60
+		A9 ` + status + ` 8D 00 60
+		D0 FE F0 FC`
+}
+
+func TestRunTest(t *testing.T) {
+	c, err := New(newCart(map[uint16]string{
+		0x8000: testProgram("00"),
+		0xfffc: "00 80",
+	}))
+	assert.NoError(t, err)
+	res, err := c.RunTest(10)
+	assert.NoError(t, err)
+	assert.Equal(t, res, TestResult{Status: 0, Text: "ok"})
+	assert.True(t, res.Passed())
+
+	c, err = New(newCart(map[uint16]string{
+		0x8000: testProgram("03"),
+		0xfffc: "00 80",
+	}))
+	assert.NoError(t, err)
+	res, err = c.RunTest(10)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Status, byte(3))
+	assert.False(t, res.Passed())
+
+	// nothing is ever reported
+	c, err = New(newCart(map[uint16]string{
+		0x8000: "D0 FE F0 FC",
+		0xfffc: "00 80",
+	}))
+	assert.NoError(t, err)
+	res, err = c.RunTest(10)
+	assert.ErrorIs(t, err, ErrTestTimeout)
+	assert.False(t, res.Passed())
+}
+
+func TestRunTestReset(t *testing.T) {
+	// the first run asks for a reset (and counts runs at $10); the second
+	// passes
+	c, err := New(newCart(map[uint16]string{
+		0x8000: "E6 10 A5 10 C9 02 F0 08 A9 81 8D 00 60 4C 00 81",
+		0x8010: "4C 00 90",
+		0x8100: "A9 DE 8D 01 60 A9 B0 8D 02 60 A9 61 8D 03 60 D0 FE F0 FC",
+		0x9000: "A9 00 8D 04 60 8D 00 60 F0 FE",
+		0xfffc: "00 80",
+	}))
+	assert.NoError(t, err)
+	res, err := c.RunTest(60)
+	assert.NoError(t, err)
+	assert.True(t, res.Passed())
+	assert.Equal(t, c.Cpu.Peek(0x10), byte(2))
+}
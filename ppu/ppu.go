@@ -0,0 +1,357 @@
+// Package ppu implements the Ricoh 2C02 picture processing unit of the NES.
+//
+// The PPU renders one pixel per dot. A frame consists of 262 (NTSC) or 312
+// (PAL) scanlines of 341 dots each, of which only 240 scanlines of 256 dots
+// are visible; the remainder is spent in (horizontal and vertical) blanking.
+
+package ppu
+
+import (
+	"gone/cartridge"
+	"gone/mem"
+)
+
+// https://www.nesdev.org/wiki/PPU
+// https://www.nesdev.org/wiki/PPU_registers
+// https://www.nesdev.org/wiki/PPU_rendering
+// https://www.nesdev.org/wiki/PPU_scrolling
+// https://www.youtube.com/watch?v=-THeUXqR3zY (OLC)
+
+const (
+	Width  = 256
+	Height = 240
+
+	dots = 341 // per scanline
+)
+
+// timing describes the frame layout of a Region.
+//
+// https://www.nesdev.org/wiki/Cycle_reference_chart
+type timing struct {
+	scanlines  int  // per frame, including the pre-render line
+	vblankLine int  // the scanline at which vblank (and the NMI) starts
+	skipDot    bool // odd frames are 1 dot shorter, while rendering
+}
+
+var (
+	ntscTiming = timing{scanlines: 262, vblankLine: 241, skipDot: true}
+	// vblank is 70 scanlines long
+	palTiming = timing{scanlines: 312, vblankLine: 241}
+	// PAL-length frames, but with a 51 line idle period before a 20 line
+	// vblank, for compatibility with NTSC games
+	dendyTiming = timing{scanlines: 312, vblankLine: 291}
+)
+
+func regionTiming(r cartridge.Region) *timing {
+	switch r {
+	case cartridge.PAL:
+		return &palTiming
+	case cartridge.Dendy:
+		return &dendyTiming
+	default:
+		return &ntscTiming
+	}
+}
+
+// The 8 registers mapped to 0x2000-0x2007 on the CPU Bus.
+const (
+	PPUCTRL   = 0x2000 // write
+	PPUMASK   = 0x2001 // write
+	PPUSTATUS = 0x2002 // read
+	OAMADDR   = 0x2003 // write
+	OAMDATA   = 0x2004 // read/write
+	PPUSCROLL = 0x2005 // write x2
+	PPUADDR   = 0x2006 // write x2
+	PPUDATA   = 0x2007 // read/write
+)
+
+// A Ppu is a mem.Device on the CPU Bus (0x2000-0x2007), and has its own
+// PpuBus, from which it reads pattern tables, nametables and palettes.
+//
+// The Ppu must be clocked (via Step) 3 times per Cpu cycle.
+type Ppu struct {
+	Bus *mem.PpuBus
+
+	// Region determines the number of scanlines per frame, and the length
+	// of vblank. It should be set before the first Step.
+	Region cartridge.Region
+
+	// Frame is the last completed frame. Each pixel is an index into the
+	// system Palette (0x00-0x3f).
+	Frame [Width * Height]byte
+	// Frames counts the frames completed so far.
+	Frames uint64
+
+	// Nmi is called whenever the NMI output of the Ppu changes (typically
+	// Cpu.SetNMI). The output is active while the vblank flag is set and
+	// NMIs are enabled in PPUCTRL; toggling either may generate another
+	// NMI during the same vblank.
+	Nmi func(active bool)
+
+	// OnScanline is called once per rendered scanline (at dot 260), if
+	// rendering is enabled. This approximates the rising edges of PPU
+	// address line A12 that clock scanline counters in mappers like MMC3.
+	OnScanline func()
+
+	timing       *timing          // of timingRegion; nil until the first Step
+	timingRegion cartridge.Region // what timing was looked up for
+
+	// the current position of the 'beam'
+	Scanline int
+	Dot      int
+	oddFrame bool
+
+	// Object Attribute Memory, i.e. sprites. 64 sprites of 4 bytes each:
+	// y, tile, attributes, x.
+	Oam [256]byte
+
+	ctrl    byte // PPUCTRL
+	mask    byte // PPUMASK
+	status  byte // PPUSTATUS (only bits 5-7)
+	oamAddr byte // OAMADDR
+
+	// 'loopy' registers, which are used for both scrolling and PPUADDR
+	//
+	// yyy NN YYYYY XXXXX
+	// ||| || ||||| +++++-- coarse X scroll
+	// ||| || +++++-------- coarse Y scroll
+	// ||| ++-------------- nametable select
+	// +++----------------- fine Y scroll
+	v uint16 // current VRAM address (15 bits)
+	t uint16 // temporary VRAM address (15 bits); the top left of the screen
+	x byte   // fine X scroll (3 bits)
+	w bool   // write toggle, shared by PPUSCROLL and PPUADDR; false = first write
+
+	readBuffer byte // PPUDATA reads (except palettes) are delayed by one read
+	openBus    byte // the last value written to any register
+	nmiOutput  bool
+
+	back [Width * Height]byte // the frame currently being drawn
+
+	bg      background
+	sprites [8]sprite
+	nSprite int
+}
+
+// PPUCTRL bits
+const (
+	ctrlIncrement       = 1 << 2 // VRAM address increment per PPUDATA access; 0: +1, 1: +32
+	ctrlSpriteTable     = 1 << 3 // 8x8 sprite pattern table; 0: 0x0000, 1: 0x1000
+	ctrlBackgroundTable = 1 << 4 // background pattern table; 0: 0x0000, 1: 0x1000
+	ctrlSpriteSize      = 1 << 5 // 0: 8x8, 1: 8x16
+	ctrlNmi             = 1 << 7 // generate an NMI at the start of vblank
+)
+
+// PPUMASK bits
+const (
+	maskGreyscale      = 1 << 0
+	maskBackgroundLeft = 1 << 1 // show background in leftmost 8 pixels
+	maskSpriteLeft     = 1 << 2 // show sprites in leftmost 8 pixels
+	maskBackground     = 1 << 3
+	maskSprites        = 1 << 4
+)
+
+// PPUSTATUS bits
+const (
+	statusOverflow   = 1 << 5
+	statusSpriteZero = 1 << 6
+	statusVblank     = 1 << 7
+)
+
+// Reset returns the Ppu to its power-up state. The contents of OAM and the
+// PpuBus are left untouched.
+func (p *Ppu) Reset() {
+	p.ctrl = 0
+	p.mask = 0
+	p.status = 0
+	p.oamAddr = 0
+	p.v, p.t, p.x, p.w = 0, 0, 0, false
+	p.readBuffer = 0
+	p.Scanline = 0
+	p.Dot = 0
+	p.oddFrame = false
+	p.updateNmi()
+}
+
+func (p *Ppu) Range() (uint16, uint16) { return 0x2000, 0x2007 }
+
+// Pixel returns the pixel at x, y of the frame currently being drawn. Pixels
+// that the beam has not reached yet still hold the previous frame. Unlike
+// Frame, this is only useful for peripherals that follow the beam, such as
+// light guns.
+func (p *Ppu) Pixel(x, y int) byte { return p.back[y*Width+x] }
+
+// increment returns the amount by which v is incremented after each PPUDATA
+// access.
+func (p *Ppu) increment() uint16 {
+	if p.ctrl&ctrlIncrement > 0 {
+		return 32 // i.e. go down one row of tiles
+	}
+	return 1
+}
+
+// Read reads one of the Ppu registers. Reading PPUSTATUS clears the vblank
+// flag and the write toggle; reading PPUDATA advances the VRAM address. These
+// side effects are skipped if readonly is true.
+func (p *Ppu) Read(addr uint16, readonly bool) byte {
+	switch addr {
+	case PPUSTATUS:
+		// the lower 5 bits are not driven, and hold whatever was last
+		// written
+		data := p.status | p.openBus&0x1f
+		if !readonly {
+			p.status &^= statusVblank
+			p.w = false
+			p.openBus = data
+			p.updateNmi()
+		}
+		return data
+
+	case OAMDATA:
+		data := p.Oam[p.oamAddr]
+		if !readonly {
+			p.openBus = data
+		}
+		return data
+
+	case PPUDATA:
+		addr := p.v & 0x3fff
+		var data byte
+		if addr >= 0x3f00 {
+			// palette reads are not buffered, but the buffer is
+			// still updated with the nametable 'underneath'
+			data = p.Bus.Read(addr) | p.openBus&0xc0
+			if !readonly {
+				p.readBuffer = p.Bus.Read(addr - 0x1000)
+			}
+		} else {
+			data = p.readBuffer
+			if !readonly {
+				p.readBuffer = p.Bus.Read(addr)
+			}
+		}
+		if !readonly {
+			p.v += p.increment()
+			p.openBus = data
+		}
+		return data
+
+	default: // write-only
+		return p.openBus
+	}
+}
+
+// Write writes to one of the Ppu registers.
+func (p *Ppu) Write(addr uint16, data byte) {
+	p.openBus = data
+
+	switch addr {
+	case PPUCTRL:
+		// enabling NMIs during vblank immediately generates one
+		p.ctrl = data
+		p.updateNmi()
+		// nametable select
+		p.t = p.t&0xf3ff | uint16(data&0x03)<<10
+
+	case PPUMASK:
+		p.mask = data
+
+	case OAMADDR:
+		p.oamAddr = data
+
+	case OAMDATA:
+		p.Oam[p.oamAddr] = data
+		p.oamAddr++
+
+	case PPUSCROLL:
+		if !p.w {
+			// coarse X, fine x
+			p.t = p.t&0xffe0 | uint16(data)>>3
+			p.x = data & 0x07
+		} else {
+			// fine Y, coarse Y
+			p.t = p.t&0x8c1f | uint16(data&0x07)<<12 | uint16(data&0xf8)<<2
+		}
+		p.w = !p.w
+
+	case PPUADDR:
+		if !p.w {
+			// high byte; bit 14 is cleared
+			p.t = p.t&0x80ff | uint16(data&0x3f)<<8
+		} else {
+			p.t = p.t&0xff00 | uint16(data)
+			p.v = p.t
+		}
+		p.w = !p.w
+
+	case PPUDATA:
+		p.Bus.Write(p.v&0x3fff, data)
+		p.v += p.increment()
+	}
+}
+
+// updateNmi recomputes the NMI output, and reports it if it has changed.
+func (p *Ppu) updateNmi() {
+	active := p.ctrl&ctrlNmi > 0 && p.status&statusVblank > 0
+	if active != p.nmiOutput {
+		p.nmiOutput = active
+		if p.Nmi != nil {
+			p.Nmi(active)
+		}
+	}
+}
+
+func (p *Ppu) rendering() bool {
+	return p.mask&(maskBackground|maskSprites) > 0
+}
+
+// currentTiming returns the timing of p.Region, which is only looked up
+// again when the Region changes.
+func (p *Ppu) currentTiming() *timing {
+	if p.timing == nil || p.timingRegion != p.Region {
+		p.timing, p.timingRegion = regionTiming(p.Region), p.Region
+	}
+	return p.timing
+}
+
+// Step advances the Ppu by a single dot.
+func (p *Ppu) Step() {
+	t := p.currentTiming()
+	visible := p.Scanline < Height
+	preRender := p.Scanline == t.scanlines-1
+
+	if p.rendering() && (visible || preRender) {
+		p.render(visible, preRender)
+	}
+	if visible && p.Dot >= 1 && p.Dot <= Width {
+		p.pixel()
+	}
+
+	switch {
+	case p.Scanline == t.vblankLine && p.Dot == 1:
+		p.status |= statusVblank
+		p.Frame = p.back
+		p.Frames++
+		p.updateNmi()
+	case preRender && p.Dot == 1:
+		p.status &^= statusVblank | statusSpriteZero | statusOverflow
+		p.updateNmi()
+	}
+
+	p.Dot++
+
+	// on odd frames, the idle dot at the start of scanline 0 is skipped
+	// when rendering
+	if t.skipDot && preRender && p.Dot == dots-1 && p.oddFrame && p.rendering() {
+		p.Dot++
+	}
+
+	if p.Dot == dots {
+		p.Dot = 0
+		p.Scanline++
+		if p.Scanline == t.scanlines {
+			p.Scanline = 0
+			p.oddFrame = !p.oddFrame
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gone/cartridge"
+	"gone/mem"
+)
+
+// chrRam is a cartridge with 8 kB of CHR-RAM and fixed mirroring.
+type chrRam struct{ data [8 * 1024]byte }
+
+func (c *chrRam) ReadChr(addr uint16) byte        { return c.data[addr] }
+func (c *chrRam) WriteChr(addr uint16, data byte) { c.data[addr] = data }
+func (c *chrRam) Mirroring() cartridge.Mirroring  { return cartridge.Vertical }
+
+func newPpu() *Ppu {
+	return &Ppu{Bus: &mem.PpuBus{Cart: &chrRam{}}}
+}
+
+// frame steps the Ppu until the current frame is completed.
+func frame(p *Ppu) {
+	n := p.Frames
+	for p.Frames == n {
+		p.Step()
+	}
+}
+
+func TestLoopy(t *testing.T) {
+	// https://www.nesdev.org/wiki/PPU_scrolling#Summary
+	p := newPpu()
+	p.Write(PPUCTRL, 0b0000_0000)
+	p.Read(PPUSTATUS, false)
+	p.Write(PPUSCROLL, 0b0111_1101)
+	assert.Equal(t, p.t, uint16(0b000_00_00000_01111))
+	assert.Equal(t, p.x, byte(0b101))
+	assert.True(t, p.w)
+	p.Write(PPUSCROLL, 0b0101_1110)
+	assert.Equal(t, p.t, uint16(0b110_00_01011_01111))
+	assert.False(t, p.w)
+	p.Write(PPUADDR, 0b0011_1101)
+	assert.Equal(t, p.t, uint16(0b011_11_01011_01111))
+	p.Write(PPUADDR, 0b1111_0000)
+	assert.Equal(t, p.t, uint16(0b011_11_01111_10000))
+	assert.Equal(t, p.v, p.t)
+
+	// reading PPUSTATUS resets the write toggle
+	p.Write(PPUADDR, 0x21)
+	p.Read(PPUSTATUS, false)
+	p.Write(PPUADDR, 0x23)
+	p.Write(PPUADDR, 0x45)
+	assert.Equal(t, p.v, uint16(0x2345))
+}
+
+func TestPpuData(t *testing.T) {
+	p := newPpu()
+	p.Bus.Write(0x2345, 0xaa)
+	p.Bus.Write(0x2346, 0xbb)
+	p.Bus.Write(0x3f05, 0x21)
+
+	p.Write(PPUADDR, 0x23)
+	p.Write(PPUADDR, 0x45)
+	assert.Equal(t, p.Read(PPUDATA, false), byte(0)) // stale buffer
+	assert.Equal(t, p.Read(PPUDATA, true), byte(0xaa))
+	assert.Equal(t, p.Read(PPUDATA, false), byte(0xaa))
+	assert.Equal(t, p.Read(PPUDATA, false), byte(0xbb))
+	assert.Equal(t, p.v, uint16(0x2348))
+
+	// palette reads are immediate
+	p.Write(PPUADDR, 0x3f)
+	p.Write(PPUADDR, 0x05)
+	assert.Equal(t, p.Read(PPUDATA, false), byte(0x21))
+
+	// +32
+	p.Write(PPUCTRL, ctrlIncrement)
+	p.Write(PPUADDR, 0x20)
+	p.Write(PPUADDR, 0x00)
+	p.Write(PPUDATA, 1)
+	p.Write(PPUDATA, 2)
+	assert.Equal(t, p.Bus.Read(0x2000), byte(1))
+	assert.Equal(t, p.Bus.Read(0x2020), byte(2))
+}
+
+func TestVblank(t *testing.T) {
+	p := newPpu()
+	var nmis int
+	p.Nmi = func(active bool) {
+		if active {
+			nmis++
+		}
+	}
+
+	for p.Scanline != 241 || p.Dot != 2 {
+		p.Step()
+	}
+	assert.Equal(t, p.Read(PPUSTATUS, true)&statusVblank, byte(statusVblank))
+	assert.Equal(t, nmis, 0) // NMIs disabled
+
+	// enabling NMIs during vblank generates one immediately, as does
+	// toggling them
+	p.Write(PPUCTRL, ctrlNmi)
+	assert.Equal(t, nmis, 1)
+	p.Write(PPUCTRL, 0)
+	p.Write(PPUCTRL, ctrlNmi)
+	assert.Equal(t, nmis, 2)
+
+	// reading PPUSTATUS clears the flag
+	assert.Equal(t, p.Read(PPUSTATUS, false)&statusVblank, byte(statusVblank))
+	assert.Equal(t, p.Read(PPUSTATUS, false)&statusVblank, byte(0))
+	p.Write(PPUCTRL, 0)
+	p.Write(PPUCTRL, ctrlNmi)
+	assert.Equal(t, nmis, 2)
+
+	frame(p)
+	assert.Equal(t, nmis, 3)
+	assert.Equal(t, p.Frames, uint64(2))
+}
+
+// tile fills the given tile of pattern table 0 with a solid colour (1-3).
+func tile(p *Ppu, n uint16, colour byte) {
+	for row := range uint16(8) {
+		p.Bus.Write(n*16+row, 0xff*(colour&1))
+		p.Bus.Write(n*16+row+8, 0xff*(colour>>1))
+	}
+}
+
+func TestRenderBackground(t *testing.T) {
+	p := newPpu()
+	tile(p, 1, 1)
+	tile(p, 2, 3)
+	p.Bus.Write(0x2000, 1) // top left
+	p.Bus.Write(0x2001, 2)
+	p.Bus.Write(0x2020, 2) // 2nd row
+	p.Bus.Write(0x3f00, 0x0f)
+	p.Bus.Write(0x3f01, 0x16)
+	p.Bus.Write(0x3f03, 0x2a)
+
+	p.Write(PPUMASK, maskBackground|maskBackgroundLeft)
+	frame(p)
+	frame(p)
+
+	assert.Equal(t, p.Frame[0], byte(0x16))
+	assert.Equal(t, p.Frame[7], byte(0x16))
+	assert.Equal(t, p.Frame[8], byte(0x2a))
+	assert.Equal(t, p.Frame[16], byte(0x0f))
+	assert.Equal(t, p.Frame[8*Width], byte(0x2a))
+
+	// fine x scroll of 4 pixels
+	p.Read(PPUSTATUS, false)
+	p.Write(PPUSCROLL, 4)
+	p.Write(PPUSCROLL, 0)
+	frame(p)
+	assert.Equal(t, p.Frame[3], byte(0x16))
+	assert.Equal(t, p.Frame[4], byte(0x2a))
+
+	// hide the leftmost 8 pixels
+	p.Write(PPUMASK, maskBackground)
+	frame(p)
+	assert.Equal(t, p.Frame[3], byte(0x0f))
+	assert.Equal(t, p.Frame[8], byte(0x2a))
+}
+
+func TestRenderSprites(t *testing.T) {
+	p := newPpu()
+	tile(p, 1, 1)
+	p.Bus.Write(0x2002, 1) // bg tile at x=16
+	p.Bus.Write(0x3f01, 0x16)
+	p.Bus.Write(0x3f11, 0x30)
+
+	// hide unused sprites below the screen
+	for i := range p.Oam {
+		p.Oam[i] = 0xff
+	}
+	// sprite 0 overlaps the background tile, 1 line lower than its y
+	copy(p.Oam[0:4], []byte{0, 1, 0, 20})
+	// sprite 1 is behind the background, but there is only backdrop
+	copy(p.Oam[4:8], []byte{100, 1, attrBehind, 100})
+
+	p.Write(PPUMASK, maskBackground|maskSprites)
+	frame(p)
+	frame(p)
+	assert.Equal(t, p.Frame[16], byte(0x16))            // background only
+	assert.Equal(t, p.Frame[0*Width+20], byte(0x16))    // sprite is 1 line lower
+	assert.Equal(t, p.Frame[1*Width+20], byte(0x30))    // sprite in front
+	assert.Equal(t, p.Frame[101*Width+100], byte(0x30)) // sprite behind backdrop
+	assert.Equal(t, p.Frame[109*Width+100], byte(0x00)) // below sprite
+	assert.Equal(t, p.Read(PPUSTATUS, true)&statusSpriteZero, byte(statusSpriteZero))
+	assert.Equal(t, p.Read(PPUSTATUS, true)&statusOverflow, byte(0))
+
+	// the flags are cleared at the end of vblank
+	for p.Scanline != 0 {
+		p.Step()
+	}
+	assert.Equal(t, p.Read(PPUSTATUS, true)&statusSpriteZero, byte(0))
+
+	// 9 sprites on one line
+	for i := range 9 {
+		copy(p.Oam[8+i*4:], []byte{50, 1, 0, byte(i * 8)})
+	}
+	frame(p)
+	assert.Equal(t, p.Read(PPUSTATUS, true)&statusOverflow, byte(statusOverflow))
+}
+
+func TestRegion(t *testing.T) {
+	for _, tc := range []struct {
+		region     cartridge.Region
+		dots       [2]int // per frame, even then odd
+		vblankLine int
+	}{
+		{cartridge.NTSC, [2]int{341 * 262, 341*262 - 1}, 241},
+		{cartridge.PAL, [2]int{341 * 312, 341 * 312}, 241},
+		{cartridge.Dendy, [2]int{341 * 312, 341 * 312}, 291},
+	} {
+		p := newPpu()
+		p.Region = tc.region
+		p.Write(PPUMASK, maskBackground)
+		frame(p)
+		assert.Equal(t, p.Scanline, tc.vblankLine, tc.region)
+
+		for _, dots := range tc.dots {
+			n := 0
+			for start := p.Frames; p.Frames == start; n++ {
+				p.Step()
+			}
+			assert.Equal(t, n, dots, tc.region)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	p := newPpu()
+	p.Frame[Width+2] = 0x16
+	img := p.Image()
+	assert.Equal(t, img.Bounds().Size().X, Width)
+	assert.Equal(t, img.RGBAAt(2, 1), Palette[0x16])
+	assert.Equal(t, img.RGBAAt(0, 0), Palette[0])
+}
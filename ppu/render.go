@@ -0,0 +1,296 @@
+package ppu
+
+import "math/bits"
+
+// https://www.nesdev.org/wiki/PPU_rendering
+// https://www.nesdev.org/wiki/PPU_scrolling#Wrapping_around
+// https://www.nesdev.org/wiki/PPU_sprite_evaluation
+// https://www.nesdev.org/w/images/default/4/4f/Ppu.svg
+
+// background holds the state of the background pipeline. Every 8 dots, the
+// next tile is fetched (in 4 steps), then loaded into the low byte of the
+// shift registers. The high byte is the tile currently being drawn.
+type background struct {
+	nextTile byte // index into the pattern table
+	nextAttr byte // 2-bit palette
+	nextLo   byte // bit plane 0 of the tile's current row
+	nextHi   byte // bit plane 1
+
+	patternLo uint16
+	patternHi uint16
+	attrLo    uint16 // the palette bits are expanded to 8 bits each
+	attrHi    uint16
+}
+
+// A sprite is an entry of OAM that is on the current scanline, with its
+// pattern data already fetched.
+type sprite struct {
+	x    byte
+	lo   byte // bit plane 0 of the current row, already flipped if needed
+	hi   byte
+	attr byte
+	zero bool // sprite 0, which can trigger the sprite zero hit
+}
+
+// sprite attribute bits
+const (
+	attrPalette    = 0x03
+	attrBehind     = 1 << 5 // behind the background
+	attrFlipX      = 1 << 6
+	attrFlipY      = 1 << 7
+	spriteCount    = 64
+	spriteLimit    = 8 // per scanline
+	spritePalettes = 4 // sprite palettes follow the 4 background palettes
+)
+
+// render performs the work of the current dot, on a visible or pre-render
+// scanline, while rendering is enabled. The structure follows OLC's
+// implementation closely.
+func (p *Ppu) render(visible bool, preRender bool) {
+	dot := p.Dot
+
+	if (dot >= 2 && dot <= 257) || (dot >= 321 && dot <= 337) {
+		p.shift()
+
+		switch (dot - 1) % 8 {
+		case 0:
+			p.load()
+			p.bg.nextTile = p.Bus.Read(0x2000 | p.v&0x0fff)
+		case 2:
+			p.bg.nextAttr = p.fetchAttr()
+		case 4:
+			p.bg.nextLo = p.Bus.Read(p.backgroundRow())
+		case 6:
+			p.bg.nextHi = p.Bus.Read(p.backgroundRow() + 8)
+		case 7:
+			p.incrementX()
+		}
+	}
+
+	switch {
+	case dot == 256:
+		p.incrementY()
+	case dot == 257:
+		p.load()
+		p.copyX()
+		if visible {
+			p.evaluateSprites()
+		} else {
+			p.nSprite = 0
+		}
+	case dot == 260:
+		if p.OnScanline != nil {
+			p.OnScanline()
+		}
+	case preRender && dot >= 280 && dot <= 304:
+		p.copyY()
+	}
+}
+
+// fetchAttr returns the 2-bit palette of the tile at v. Each attribute byte
+// covers 4x4 tiles, 2 bits per 2x2 quadrant.
+func (p *Ppu) fetchAttr() byte {
+	addr := 0x23c0 | p.v&0x0c00 | (p.v>>4)&0x38 | (p.v>>2)&0x07
+	shift := (p.v>>4)&0x04 | p.v&0x02
+	return (p.Bus.Read(addr) >> shift) & 0x03
+}
+
+// backgroundRow returns the address of bit plane 0 of the current row of
+// the next background tile.
+func (p *Ppu) backgroundRow() uint16 {
+	var table uint16
+	if p.ctrl&ctrlBackgroundTable > 0 {
+		table = 0x1000
+	}
+	fineY := (p.v >> 12) & 0x07
+	return table + uint16(p.bg.nextTile)*16 + fineY
+}
+
+func (p *Ppu) shift() {
+	if p.mask&maskBackground == 0 {
+		return
+	}
+	p.bg.patternLo <<= 1
+	p.bg.patternHi <<= 1
+	p.bg.attrLo <<= 1
+	p.bg.attrHi <<= 1
+}
+
+func (p *Ppu) load() {
+	p.bg.patternLo = p.bg.patternLo&0xff00 | uint16(p.bg.nextLo)
+	p.bg.patternHi = p.bg.patternHi&0xff00 | uint16(p.bg.nextHi)
+	p.bg.attrLo &= 0xff00
+	p.bg.attrHi &= 0xff00
+	if p.bg.nextAttr&0x01 > 0 {
+		p.bg.attrLo |= 0x00ff
+	}
+	if p.bg.nextAttr&0x02 > 0 {
+		p.bg.attrHi |= 0x00ff
+	}
+}
+
+// incrementX moves v to the next tile, switching to the horizontally adjacent
+// nametable at the end of a row.
+func (p *Ppu) incrementX() {
+	if p.v&0x001f == 31 {
+		p.v &^= 0x001f
+		p.v ^= 0x0400
+	} else {
+		p.v++
+	}
+}
+
+// incrementY moves v to the next row of pixels, switching to the vertically
+// adjacent nametable at the end of a column. Row 29 is the last row of
+// tiles; rows 30 and 31 are attributes, and wrap to 0 without switching
+// nametables.
+func (p *Ppu) incrementY() {
+	if p.v&0x7000 != 0x7000 {
+		p.v += 0x1000 // fine Y
+		return
+	}
+	p.v &^= 0x7000
+	y := (p.v & 0x03e0) >> 5
+	switch y {
+	case 29:
+		y = 0
+		p.v ^= 0x0800
+	case 31:
+		y = 0
+	default:
+		y++
+	}
+	p.v = p.v&^0x03e0 | y<<5
+}
+
+// copyX copies the horizontal components of t into v.
+func (p *Ppu) copyX() { p.v = p.v&0xfbe0 | p.t&0x041f }
+
+// copyY copies the vertical components of t into v.
+func (p *Ppu) copyY() { p.v = p.v&0x841f | p.t&0x7be0 }
+
+// evaluateSprites finds the (first 8) sprites on the current scanline, and
+// fetches their pattern data. These are drawn on the next scanline, which is
+// why sprites appear 1 scanline lower than their y coordinate.
+func (p *Ppu) evaluateSprites() {
+	height := 8
+	if p.ctrl&ctrlSpriteSize > 0 {
+		height = 16
+	}
+
+	n := 0
+	for i := range spriteCount {
+		y := p.Oam[i*4]
+		tile := uint16(p.Oam[i*4+1])
+		attr := p.Oam[i*4+2]
+		x := p.Oam[i*4+3]
+
+		row := p.Scanline - int(y)
+		if row < 0 || row >= height {
+			continue
+		}
+		if n == spriteLimit {
+			// note: the hardware bug that causes false positives
+			// (and negatives) is not emulated
+			p.status |= statusOverflow
+			break
+		}
+
+		if attr&attrFlipY > 0 {
+			row = height - 1 - row
+		}
+
+		var table uint16
+		if height == 8 {
+			if p.ctrl&ctrlSpriteTable > 0 {
+				table = 0x1000
+			}
+		} else {
+			// 8x16 sprites ignore ctrlSpriteTable; the table is
+			// selected by bit 0 of the tile
+			table = (tile & 1) * 0x1000
+			tile &^= 1
+			if row >= 8 {
+				tile++
+				row -= 8
+			}
+		}
+
+		addr := table + tile*16 + uint16(row)
+		lo := p.Bus.Read(addr)
+		hi := p.Bus.Read(addr + 8)
+		if attr&attrFlipX > 0 {
+			lo = bits.Reverse8(lo)
+			hi = bits.Reverse8(hi)
+		}
+
+		p.sprites[n] = sprite{x: x, lo: lo, hi: hi, attr: attr, zero: i == 0}
+		n++
+	}
+	p.nSprite = n
+}
+
+// pixel draws the pixel at the current dot, combining background and sprites
+// according to their priority.
+func (p *Ppu) pixel() {
+	x := p.Dot - 1
+
+	var bgPixel, bgPalette byte
+	if p.mask&maskBackground > 0 && (x >= 8 || p.mask&maskBackgroundLeft > 0) {
+		bit := uint16(0x8000) >> p.x
+		bgPixel = b2i(p.bg.patternLo&bit > 0) | b2i(p.bg.patternHi&bit > 0)<<1
+		bgPalette = b2i(p.bg.attrLo&bit > 0) | b2i(p.bg.attrHi&bit > 0)<<1
+	}
+
+	var spPixel, spPalette byte
+	var spBehind, spZero bool
+	if p.mask&maskSprites > 0 && (x >= 8 || p.mask&maskSpriteLeft > 0) {
+		// sprites earlier in OAM have priority
+		for _, s := range p.sprites[:p.nSprite] {
+			offset := x - int(s.x)
+			if offset < 0 || offset > 7 {
+				continue
+			}
+			px := (s.lo>>(7-offset))&1 | ((s.hi>>(7-offset))&1)<<1
+			if px == 0 {
+				continue
+			}
+			spPixel = px
+			spPalette = s.attr&attrPalette + spritePalettes
+			spBehind = s.attr&attrBehind > 0
+			spZero = s.zero
+			break
+		}
+	}
+
+	var addr byte // 0 is the universal background colour
+	switch {
+	case bgPixel == 0 && spPixel == 0:
+	case bgPixel == 0:
+		addr = spPalette*4 + spPixel
+	case spPixel == 0:
+		addr = bgPalette*4 + bgPixel
+	default:
+		if spZero && x < 255 {
+			p.status |= statusSpriteZero
+		}
+		if spBehind {
+			addr = bgPalette*4 + bgPixel
+		} else {
+			addr = spPalette*4 + spPixel
+		}
+	}
+
+	colour := p.Bus.Read(0x3f00 + uint16(addr))
+	if p.mask&maskGreyscale > 0 {
+		colour &= 0x30
+	}
+	p.back[p.Scanline*Width+x] = colour
+}
+
+func b2i(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
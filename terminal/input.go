@@ -0,0 +1,81 @@
+package terminal
+
+import (
+	"sync"
+	"time"
+
+	"gone/controller"
+)
+
+// A KeyMap maps keys (as named by bubbletea, e.g. "up" or "x") to buttons of
+// the first controller.
+type KeyMap map[string]controller.Buttons
+
+// DefaultKeys is the KeyMap used by Play.
+var DefaultKeys = KeyMap{
+	"up":    controller.Up,
+	"down":  controller.Down,
+	"left":  controller.Left,
+	"right": controller.Right,
+	"x":     controller.A,
+	"z":     controller.B,
+	"enter": controller.Start,
+	"tab":   controller.Select,
+}
+
+// terminals only report key presses (and repeats), not releases. a button is
+// therefore held for a while after each press, which must be long enough to
+// bridge the delay before the key starts repeating.
+const holdTime = 250 * time.Millisecond
+
+// input tracks the state of the buttons. It is written by the UI, and read by
+// the Runner, hence the lock.
+type input struct {
+	mu    sync.Mutex
+	until [8]time.Time // per button, in the order of controller.Buttons
+}
+
+// press holds the buttons until holdTime has elapsed. Pressing a direction
+// releases the opposite one, since it was most likely released in the
+// meantime.
+func (in *input) press(b controller.Buttons, now time.Time) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, pair := range [][2]controller.Buttons{
+		{controller.Up, controller.Down},
+		{controller.Left, controller.Right},
+	} {
+		switch {
+		case b&pair[0] > 0:
+			in.release(pair[1])
+		case b&pair[1] > 0:
+			in.release(pair[0])
+		}
+	}
+	for i := range in.until {
+		if b&(1<<i) > 0 {
+			in.until[i] = now.Add(holdTime)
+		}
+	}
+}
+
+func (in *input) release(b controller.Buttons) {
+	for i := range in.until {
+		if b&(1<<i) > 0 {
+			in.until[i] = time.Time{}
+		}
+	}
+}
+
+// buttons returns the buttons held at the given time.
+func (in *input) buttons(now time.Time) controller.Buttons {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var b controller.Buttons
+	for i, t := range in.until {
+		if now.Before(t) {
+			b |= 1 << i
+		}
+	}
+	return b
+}
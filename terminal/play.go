@@ -0,0 +1,161 @@
+package terminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"gone/nes"
+	"gone/ppu"
+)
+
+type frame = [ppu.Width * ppu.Height]byte
+
+// screen holds the last frame completed by the Runner, until the UI gets
+// around to drawing it. Frames are dropped if the terminal cannot keep up.
+type screen struct {
+	mu     sync.Mutex
+	frame  frame
+	frames uint64
+}
+
+type (
+	tickMsg time.Time
+	doneMsg struct{ err error }
+)
+
+// redraws are paced by the UI (rather than the Runner), so that a slow
+// terminal never slows down emulation
+const redrawInterval = time.Second / 60
+
+func tick() tea.Cmd {
+	return tea.Tick(redrawInterval, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+type model struct {
+	runner *nes.Runner
+	keys   KeyMap
+	input  *input
+	screen *screen
+	scale  float64 // 0: fit the terminal
+
+	cols, rows int
+	frame      frame
+	frames     uint64
+	fps        float64
+	lastFps    time.Time
+	lastFrames uint64
+}
+
+func (m *model) Init() tea.Cmd { return tick() }
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.cols, m.rows = msg.Width, msg.Height
+
+	case tea.KeyMsg:
+		switch s := msg.String(); s {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		case "f":
+			if m.runner.Speed() == nes.Uncapped {
+				m.runner.SetSpeed(nes.Normal)
+			} else {
+				m.runner.SetSpeed(nes.Uncapped)
+			}
+		default:
+			if b, ok := m.keys[s]; ok {
+				m.input.press(b, time.Now())
+			}
+		}
+
+	case tickMsg:
+		m.screen.mu.Lock()
+		m.frame = m.screen.frame
+		m.frames = m.screen.frames
+		m.screen.mu.Unlock()
+
+		if t := time.Time(msg); t.Sub(m.lastFps) >= time.Second {
+			m.fps = float64(m.frames-m.lastFrames) / t.Sub(m.lastFps).Seconds()
+			m.lastFps, m.lastFrames = t, m.frames
+		}
+		return m, tick()
+
+	case doneMsg:
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m *model) View() string {
+	scale := m.scale
+	if scale == 0 {
+		scale = fit(m.cols, m.rows-1) // leave a line for the status
+	}
+	speed := "normal"
+	if m.runner.Speed() == nes.Uncapped {
+		speed = "fast"
+	}
+	return render(&m.frame, scale) + fmt.Sprintf(
+		"\n%.0f fps (%s)  arrows, x=A z=B enter=Start tab=Select  f=fast-forward q=quit",
+		m.fps, speed,
+	)
+}
+
+// Play runs r in the terminal (in the alternate screen) until the user quits,
+// or until an error occurs. After every frame, the first controller is
+// updated from the keyboard (see DefaultKeys), then onFrame (if not nil) is
+// called; if it returns an error, Play stops and returns it.
+//
+// scale is the size of the picture, relative to 256x240 pixels (i.e. 256x120
+// cells); if 0, the picture is as large as the terminal allows, and follows
+// its size.
+func Play(r *nes.Runner, scale float64, onFrame func(*nes.Console) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &model{
+		runner:  r,
+		keys:    DefaultKeys,
+		input:   &input{},
+		screen:  &screen{},
+		scale:   scale,
+		lastFps: time.Now(),
+	}
+	p := tea.NewProgram(m, tea.WithAltScreen())
+
+	done := make(chan error, 1)
+	go func() {
+		err := r.Run(ctx, func(c *nes.Console) error {
+			c.Controllers[0].Buttons = m.input.buttons(time.Now())
+
+			m.screen.mu.Lock()
+			m.screen.frame = c.Ppu.Frame
+			m.screen.frames++
+			m.screen.mu.Unlock()
+
+			if onFrame != nil {
+				return onFrame(c)
+			}
+			return nil
+		})
+		done <- err
+		p.Send(doneMsg{err}) // no-op if the UI has already quit
+	}()
+
+	_, err := p.Run()
+	cancel()
+	runErr := <-done
+	switch {
+	case err != nil:
+		return err
+	case errors.Is(runErr, context.Canceled):
+		return nil
+	}
+	return runErr
+}
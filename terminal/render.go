@@ -0,0 +1,89 @@
+// Package terminal plays a Console in a terminal, without any graphical
+// stack. Frames are drawn with half-block characters in truecolor, and the
+// keyboard is mapped to the controllers.
+
+package terminal
+
+import (
+	"strconv"
+	"strings"
+
+	"gone/ppu"
+)
+
+// https://en.wikipedia.org/wiki/ANSI_escape_code#24-bit
+//
+// each cell holds 2 pixels: the upper half block (▀) is drawn in the
+// foreground colour (top pixel), and the rest of the cell in the background
+// colour (bottom pixel). at a scale of 1, a frame takes 256x120 cells.
+
+const upperHalf = "▀"
+
+// fit returns the largest scale at which a frame fits in the given number of
+// cells. The scale is not rounded, so pixels may be uneven.
+func fit(cols, rows int) float64 {
+	return max(min(
+		float64(cols)/ppu.Width,
+		float64(rows*2)/ppu.Height,
+	), 0)
+}
+
+// size returns the number of cells taken by a frame at the given scale.
+func size(scale float64) (cols, rows int) {
+	return int(ppu.Width * scale), int(ppu.Height * scale / 2)
+}
+
+// render draws a frame (as found in Ppu.Frame), scaled by nearest neighbour.
+// Colour codes are only emitted when the colour changes, which keeps the
+// output (and the work of the terminal) small.
+func render(frame *[ppu.Width * ppu.Height]byte, scale float64) string {
+	cols, rows := size(scale)
+	if cols == 0 || rows == 0 {
+		return ""
+	}
+
+	// precompute the source column of each cell
+	xs := make([]int, cols)
+	for x := range xs {
+		xs[x] = min(int(float64(x)/scale), ppu.Width-1)
+	}
+
+	var sb strings.Builder
+	sb.Grow(cols * rows * 8)
+	for y := range rows {
+		top := min(int(float64(2*y)/scale), ppu.Height-1) * ppu.Width
+		bottom := min(int(float64(2*y+1)/scale), ppu.Height-1) * ppu.Width
+
+		fg, bg := -1, -1
+		for _, x := range xs {
+			if c := int(frame[top+x] & 0x3f); c != fg {
+				fg = c
+				sgr(&sb, 38, c)
+			}
+			if c := int(frame[bottom+x] & 0x3f); c != bg {
+				bg = c
+				sgr(&sb, 48, c)
+			}
+			sb.WriteString(upperHalf)
+		}
+		sb.WriteString("\x1b[0m")
+		if y < rows-1 {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// sgr sets the foreground (38) or background (48) to a colour of the Palette.
+func sgr(sb *strings.Builder, layer int, c int) {
+	rgb := ppu.Palette[c]
+	sb.WriteString("\x1b[")
+	sb.WriteString(strconv.Itoa(layer))
+	sb.WriteString(";2;")
+	sb.WriteString(strconv.Itoa(int(rgb.R)))
+	sb.WriteByte(';')
+	sb.WriteString(strconv.Itoa(int(rgb.G)))
+	sb.WriteByte(';')
+	sb.WriteString(strconv.Itoa(int(rgb.B)))
+	sb.WriteByte('m')
+}
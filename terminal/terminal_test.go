@@ -0,0 +1,66 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gone/controller"
+	"gone/ppu"
+)
+
+func TestFit(t *testing.T) {
+	assert.Equal(t, fit(256, 120), 1.0)
+	assert.Equal(t, fit(512, 120), 1.0) // limited by height
+	assert.Equal(t, fit(128, 200), 0.5) // limited by width
+	assert.Equal(t, fit(0, 0), 0.0)
+
+	cols, rows := size(0.5)
+	assert.Equal(t, cols, 128)
+	assert.Equal(t, rows, 60)
+}
+
+// color returns the escape code for colour c of the Palette.
+func color(layer int, c byte) string {
+	rgb := ppu.Palette[c]
+	return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", layer, rgb.R, rgb.G, rgb.B)
+}
+
+func TestRender(t *testing.T) {
+	var f frame
+	f[0] = 0x16 // top left: red
+	f[ppu.Width] = 0x2a
+	f[ppu.Width+1] = 0x2a // below: green
+
+	lines := strings.Split(render(&f, 1), "\n")
+	assert.Equal(t, len(lines), ppu.Height/2)
+
+	// colour codes are only emitted on change
+	assert.True(t, strings.HasPrefix(lines[0], ""+
+		color(38, 0x16)+color(48, 0x2a)+upperHalf+
+		color(38, 0x00)+upperHalf+
+		color(48, 0x00)+upperHalf+upperHalf,
+	))
+	assert.Equal(t, lines[1], color(38, 0)+color(48, 0)+strings.Repeat(upperHalf, ppu.Width)+"\x1b[0m")
+
+	assert.Equal(t, render(&f, 0), "")
+	assert.Equal(t, strings.Count(render(&f, 0.5), "\n"), 59)
+	assert.Equal(t, strings.Count(render(&f, 0.5), upperHalf), 128*60)
+}
+
+func TestInput(t *testing.T) {
+	var in input
+	now := time.Now()
+	in.press(controller.A|controller.Left, now)
+	assert.Equal(t, in.buttons(now), controller.A|controller.Left)
+
+	// the opposite direction is released at once
+	in.press(controller.Right, now.Add(holdTime/2))
+	assert.Equal(t, in.buttons(now.Add(holdTime/2)), controller.A|controller.Right)
+
+	assert.Equal(t, in.buttons(now.Add(holdTime)), controller.Right)
+	assert.Equal(t, in.buttons(now.Add(2*holdTime)), controller.Buttons(0))
+}